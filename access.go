@@ -0,0 +1,160 @@
+package ff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const dialTimeout = 30 * time.Second
+
+var ErrForbiddenUpstream = errors.New("forbidden upstream")
+
+// blockedPrefixes complements netip.Addr's classification with ranges that
+// are not routable on the public internet.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// AccessConfig restricts which upstreams ff may contact. Private, loopback,
+// link-local (including cloud metadata endpoints) and other non-public
+// addresses are refused after DNS resolution unless listed in AllowedNetworks.
+type AccessConfig struct {
+	// AllowedSchemes defaults to http and https.
+	AllowedSchemes []string `json:"allowedSchemes,omitempty"`
+	// AllowedHosts, when not empty, is the only set of hosts that may be fetched.
+	// Entries are host names or "*.example.com" wildcards, as in HostRule.
+	AllowedHosts []string `json:"allowedHosts,omitempty"`
+	DeniedHosts  []string `json:"deniedHosts,omitempty"`
+	// AllowedNetworks lists CIDRs exempt from the non-public address check.
+	AllowedNetworks []string `json:"allowedNetworks,omitempty"`
+}
+
+// CheckURL applies the scheme and host lists to an upstream URL.
+func (a *AccessConfig) CheckURL(u *url.URL) error {
+	schemes := a.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+
+	if !slices.Contains(schemes, strings.ToLower(u.Scheme)) {
+		return fmt.Errorf("%w: scheme %q is not allowed", ErrForbiddenUpstream, u.Scheme)
+	}
+
+	host := u.Hostname()
+
+	for _, pattern := range a.DeniedHosts {
+		if matchHost(pattern, host) {
+			return fmt.Errorf("%w: host %q is denied", ErrForbiddenUpstream, host)
+		}
+	}
+
+	if len(a.AllowedHosts) > 0 && !slices.ContainsFunc(a.AllowedHosts, func(pattern string) bool {
+		return matchHost(pattern, host)
+	}) {
+		return fmt.Errorf("%w: host %q is not allowed", ErrForbiddenUpstream, host)
+	}
+
+	return nil
+}
+
+// CheckAddr refuses non-public addresses that are not explicitly allowed.
+func (a *AccessConfig) CheckAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+
+	for _, network := range a.AllowedNetworks {
+		prefix, err := netip.ParsePrefix(network)
+		if err == nil && prefix.Contains(addr) {
+			return nil
+		}
+	}
+
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() ||
+		slices.ContainsFunc(blockedPrefixes, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+		return fmt.Errorf("%w: address %s is not public", ErrForbiddenUpstream, addr)
+	}
+
+	return nil
+}
+
+// CheckHost resolves host, unless it is an IP literal, and checks every
+// address it resolves to.
+func (a *AccessConfig) CheckHost(ctx context.Context, host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return a.CheckAddr(addr)
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+
+	for _, addr := range addrs {
+		if err := a.CheckAddr(addr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// proxyAddrKey carries the address of the proxy a request is sent through,
+// set by upstreamTransport for the dialer.
+type proxyAddrKey struct{}
+
+// guardedDialContext checks the address a connection is actually made to,
+// after DNS resolution, so DNS rebinding and redirects cannot reach internal
+// services. Connections to the proxy a request is sent through are not
+// checked; upstreamTransport checks the proxied target instead.
+func (a *AccessConfig) guardedDialContext() func(context.Context, string, string) (net.Conn, error) {
+	plain := &net.Dialer{Timeout: dialTimeout}
+	guarded := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrForbiddenUpstream, err)
+			}
+
+			return a.CheckAddr(addrPort.Addr())
+		},
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialer := guarded
+		if proxyAddr, _ := ctx.Value(proxyAddrKey{}).(string); proxyAddr != "" && address == proxyAddr {
+			dialer = plain
+		}
+
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial upstream: %w", err)
+		}
+
+		return conn, nil
+	}
+}
+
+func matchHost(pattern string, host string) bool {
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+
+	return host == pattern
+}
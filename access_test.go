@@ -0,0 +1,164 @@
+package ff_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func TestAccessConfigCheckAddr(t *testing.T) {
+	t.Parallel()
+
+	access := &ff.AccessConfig{AllowedNetworks: []string{"10.1.0.0/16"}}
+
+	for _, tt := range []struct {
+		addr    string
+		allowed bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"10.1.2.3", true},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00:ec2::254", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	} {
+		t.Run(tt.addr, func(t *testing.T) {
+			t.Parallel()
+
+			err := access.CheckAddr(netip.MustParseAddr(tt.addr))
+			assert.Equal(t, err == nil, tt.allowed, "%v", err)
+		})
+	}
+}
+
+func TestAccessConfigCheckURL(t *testing.T) {
+	t.Parallel()
+
+	access := &ff.AccessConfig{
+		AllowedHosts: []string{"*.example.com", "example.org"},
+		DeniedHosts:  []string{"private.example.com"},
+	}
+
+	for _, tt := range []struct {
+		rawURL  string
+		allowed bool
+	}{
+		{"https://www.example.com/feed", true},
+		{"http://example.org/feed", true},
+		{"ftp://example.org/feed", false},
+		{"file:///etc/passwd", false},
+		{"https://private.example.com/feed", false},
+		{"https://example.net/feed", false},
+	} {
+		t.Run(tt.rawURL, func(t *testing.T) {
+			t.Parallel()
+
+			u, err := url.Parse(tt.rawURL)
+			assert.NilError(t, err)
+
+			err = access.CheckURL(u)
+			assert.Equal(t, err == nil, tt.allowed, "%v", err)
+		})
+	}
+}
+
+func TestUpstreamClientBlocksPrivateAddresses(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, err := ff.NewUpstreamClient(ff.ClientConfig{Access: &ff.AccessConfig{}})
+	assert.NilError(t, err)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	assert.NilError(t, err)
+
+	resp, err := client.Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	assert.Assert(t, errors.Is(err, ff.ErrForbiddenUpstream), "%v", err)
+}
+
+func TestUpstreamClientChecksRedirects(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://denied.example.com/", http.StatusFound)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, err := ff.NewUpstreamClient(ff.ClientConfig{Access: &ff.AccessConfig{
+		DeniedHosts:     []string{"denied.example.com"},
+		AllowedNetworks: []string{"127.0.0.0/8"},
+	}})
+	assert.NilError(t, err)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+"/redirect", nil)
+	assert.NilError(t, err)
+
+	resp, err := client.Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	assert.Assert(t, errors.Is(err, ff.ErrForbiddenUpstream), "%v", err)
+}
+
+func TestUpstreamClientChecksProxiedTargets(t *testing.T) {
+	t.Parallel()
+
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	client, err := ff.NewUpstreamClient(ff.ClientConfig{Proxy: proxy.URL, Access: &ff.AccessConfig{}})
+	assert.NilError(t, err)
+
+	get := func(target string) error {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+		assert.NilError(t, err)
+
+		resp, err := client.Do(req)
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		return err
+	}
+
+	err = get("http://169.254.169.254/latest/meta-data/")
+	assert.Assert(t, errors.Is(err, ff.ErrForbiddenUpstream), "%v", err)
+
+	assert.NilError(t, get("http://93.184.215.14/feed"), "the loopback proxy itself may be dialed")
+	assert.Equal(t, <-proxied, "http://93.184.215.14/feed")
+}
@@ -12,6 +12,8 @@ import (
 	"path/filepath"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -24,26 +26,69 @@ const (
 var ErrNoResponseBody = errors.New("no response body to cache")
 
 type CacheMiddleware struct {
-	TmpDir    string
-	next      http.Handler
-	etags     map[string]string
-	etagMutex sync.RWMutex
-	fsys      fs.FS
+	TmpDir string
+	// Upstream, when set, replaces the HEAD based freshness check: a rendered
+	// entry stays fresh while the upstream document it was built from is unchanged.
+	Upstream *UpstreamCache
+	// Canonicalize, when set, reduces the query to the pipeline it describes
+	// before the cache key is computed (see CanonicalQuery).
+	Canonicalize func(url.Values) url.Values
+	// Client is used for the HEAD freshness checks; a plain client is used when nil.
+	Client *http.Client
+	// Metrics, when set, counts cache hits, misses, stale entries and evictions.
+	Metrics    *Metrics
+	next       http.Handler
+	etags      map[string]string
+	etagMutex  sync.RWMutex
+	entries    map[string]cacheEntry
+	entryMutex sync.RWMutex
+	fsys       fs.FS
+	refreshes  sync.WaitGroup
+}
+
+// cacheEntry is the bookkeeping kept for a rendered cache file.
+type cacheEntry struct {
+	query           string
+	upstreamURL     string
+	upstreamVersion string
+	// etag identifies the rendered content and is sent to ff's own clients.
+	etag         string
+	cacheControl string
+	// expires is when the max-age of cacheControl runs out, zero without one.
+	expires time.Time
+	// dependencies are the versions of the other upstream documents the entry
+	// was built from, such as child sitemaps and pages, by URL.
+	dependencies map[string]string
+}
+
+// currentCacheControl is cacheControl with max-age reduced by the time the
+// entry has been cached.
+func (e cacheEntry) currentCacheControl(now time.Time) string {
+	if e.expires.IsZero() {
+		return e.cacheControl
+	}
+
+	return setDirectiveSeconds(e.cacheControl, "max-age", e.expires.Sub(now))
 }
 
 func NewCacheMiddleware(next http.Handler) (*CacheMiddleware, error) {
 	tmpDir := os.TempDir()
 	cacheDir := filepath.Join(tmpDir, "ff-cache")
 
+	return NewCacheMiddlewareWithDir(next, cacheDir)
+}
+
+func NewCacheMiddlewareWithDir(next http.Handler, cacheDir string) (*CacheMiddleware, error) {
 	if err := os.MkdirAll(cacheDir, dirPerms); err != nil {
 		return nil, fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
 	return &CacheMiddleware{
-		TmpDir: cacheDir,
-		next:   next,
-		etags:  make(map[string]string),
-		fsys:   os.DirFS(cacheDir),
+		TmpDir:  cacheDir,
+		next:    next,
+		etags:   make(map[string]string),
+		entries: make(map[string]cacheEntry),
+		fsys:    os.DirFS(cacheDir),
 	}, nil
 }
 
@@ -56,6 +101,7 @@ func (c *CacheMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	stat, err := os.Stat(cachePath)
 	if err != nil {
 		// Cache miss - generate new response
+		c.cacheOutcome(r.Context(), CacheMiss, cacheKey)
 		c.generateAndCacheResponse(w, r, queries, cachePath, cacheKey)
 
 		return
@@ -65,25 +111,40 @@ func (c *CacheMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	upstreamURLs := queries["url"]
 	if len(upstreamURLs) == 0 {
 		// No URL parameter - serve cached file directly
+		c.cacheOutcome(r.Context(), CacheHit, cacheKey)
 		c.serveFileWithCharset(w, r, cacheKey)
 
 		return
 	}
 
 	// Check if cache is fresh
-	if !c.IsCacheFresh(r.Context(), upstreamURLs[0], cacheKey, stat.ModTime()) {
-		// Cache is stale - remove and regenerate
-		os.Remove(cachePath)
-		c.RemoveETag(cacheKey)
+	fresh, fetched := c.checkFreshness(r.Context(), upstreamURLs[0], cacheKey, stat.ModTime())
+	if !fresh {
+		// Cache is stale - remove and regenerate from the documents just fetched
+		c.cacheOutcome(r.Context(), CacheStale, cacheKey)
+		c.remove(cacheKey)
+
+		if fetched != nil {
+			r = r.WithContext(contextWithDocuments(r.Context(), fetched))
+		}
+
 		c.generateAndCacheResponse(w, r, queries, cachePath, cacheKey)
 
 		return
 	}
 
 	// Cache is fresh - serve it
+	c.cacheOutcome(r.Context(), CacheHit, cacheKey)
 	c.serveFileWithCharset(w, r, cacheKey)
 }
 
+// cacheOutcome counts, logs and traces the result of a cache lookup.
+func (c *CacheMiddleware) cacheOutcome(ctx context.Context, outcome string, cacheKey string) {
+	c.Metrics.cacheOutcome(outcome)
+	trace.SpanFromContext(ctx).SetAttributes(AttrCacheOutcome.String(outcome))
+	Logger(ctx).DebugContext(ctx, "cache "+outcome, "key", cacheKey)
+}
+
 func (c *CacheMiddleware) generateAndCacheResponse(
 	w http.ResponseWriter, r *http.Request, queries url.Values, cachePath, cacheKey string,
 ) {
@@ -95,25 +156,67 @@ func (c *CacheMiddleware) generateAndCacheResponse(
 		statusCode:      http.StatusOK,
 	}
 
+	ctx, fetched := contextWithFetchRecord(r.Context())
+	r = r.WithContext(ctx)
+
 	c.next.ServeHTTP(responseRecorder, r)
 
+	// Errors and no-store responses are passed through as they are and never cached
+	if responseRecorder.statusCode != http.StatusOK || hasDirective(w.Header().Get("Cache-Control"), "no-store") {
+		w.WriteHeader(responseRecorder.statusCode)
+		_, _ = w.Write(responseRecorder.body)
+
+		return
+	}
+
+	entry := cacheEntry{
+		query:        queries.Encode(),
+		etag:         w.Header().Get("ETag"),
+		cacheControl: w.Header().Get("Cache-Control"),
+	}
+
+	if maxAge, ok := parseDirectiveSeconds(entry.cacheControl, "max-age"); ok {
+		entry.expires = time.Now().Add(maxAge)
+	}
+
 	upstreamURLs := queries["url"]
 	if len(upstreamURLs) > 0 {
-		responseRecorder.captureAndStoreETag(r.Context(), upstreamURLs[0])
+		entry.upstreamURL = upstreamURLs[0]
+
+		if c.Upstream != nil {
+			// The version of the document rendered, which a concurrent fetch
+			// may already have replaced in the upstream cache.
+			entry.upstreamVersion = fetched.version(upstreamURLs[0])
+			entry.dependencies = fetched.versionsExcept(upstreamURLs[0])
+		} else {
+			responseRecorder.captureAndStoreETag(r.Context(), upstreamURLs[0])
+		}
 	}
 
 	// Write response to cache file and serve from filesystem
 	if err := responseRecorder.writeToCache(); err != nil {
+		Logger(r.Context()).ErrorContext(r.Context(), "cache store failed", "key", cacheKey, "error", err)
 		http.Error(w, "Failed to cache response", http.StatusInternalServerError)
 
 		return
 	}
 
+	if entry.etag == "" {
+		entry.etag = fmt.Sprintf(`"%x"`, sha256.Sum256(responseRecorder.body))
+	}
+
+	c.storeEntry(cacheKey, entry)
+	Logger(r.Context()).DebugContext(r.Context(), "cache store", "key", cacheKey, "bytes", len(responseRecorder.body))
+
 	// Serve the cached file
 	c.serveFileWithCharset(w, r, cacheKey)
 }
 
 func (c *CacheMiddleware) GetCacheKey(params url.Values) string {
+	if c.Canonicalize != nil {
+		params = c.Canonicalize(params)
+	}
+
 	h := sha256.New()
 	h.Write([]byte(params.Encode()))
 
@@ -123,6 +226,17 @@ func (c *CacheMiddleware) GetCacheKey(params url.Values) string {
 func (c *CacheMiddleware) serveFileWithCharset(w http.ResponseWriter, r *http.Request, filename string) {
 	// Set Content-Type with charset before serving the file
 	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+
+	// ServeFileFS answers If-None-Match with 304 when the ETag header is set
+	entry := c.storedEntry(filename)
+	if entry.etag != "" {
+		w.Header().Set("ETag", entry.etag)
+	}
+
+	if entry.cacheControl != "" {
+		w.Header().Set("Cache-Control", entry.currentCacheControl(time.Now()))
+	}
+
 	http.ServeFileFS(w, r, c.fsys, filename)
 }
 
@@ -138,6 +252,33 @@ type ResponseRecorder struct {
 func (c *CacheMiddleware) IsCacheFresh(
 	ctx context.Context, upstreamURL string, cacheKey string, cacheTime time.Time,
 ) bool {
+	fresh, _ := c.checkFreshness(ctx, upstreamURL, cacheKey, cacheTime)
+
+	return fresh
+}
+
+// checkFreshness is IsCacheFresh, also returning the upstream documents it
+// fetched when Upstream is set.
+func (c *CacheMiddleware) checkFreshness(
+	ctx context.Context, upstreamURL string, cacheKey string, cacheTime time.Time,
+) (bool, *fetchRecord) {
+	ctx, span := StartSpan(ctx, "cache.freshness",
+		AttrUpstreamHost.String(hostOf(upstreamURL)), AttrUpstreamURL.String(upstreamURL))
+	defer span.End()
+
+	if c.Upstream != nil {
+		return c.isUpstreamUnchanged(ctx, upstreamURL, cacheKey)
+	}
+
+	return c.isHeadUnchanged(ctx, upstreamURL, cacheKey, cacheTime), nil
+}
+
+// isHeadUnchanged checks the upstream with a HEAD request against the
+// stored ETag or the time the entry was cached.
+func (c *CacheMiddleware) isHeadUnchanged(
+	ctx context.Context, upstreamURL string, cacheKey string, cacheTime time.Time,
+) bool {
+
 	req, err := http.NewRequestWithContext(ctx, httpMethodHead, upstreamURL, nil) // #nosec G704
 	if err != nil {
 		return true
@@ -148,11 +289,7 @@ func (c *CacheMiddleware) IsCacheFresh(
 		req.Header.Set("If-None-Match", storedETag)
 	}
 
-	client := &http.Client{
-		Timeout: requestTimeout,
-	}
-
-	resp, err := client.Do(req) // #nosec G704
+	resp, err := c.httpClient().Do(req) // #nosec G704
 	if err != nil {
 		return true
 	}
@@ -178,6 +315,70 @@ func (c *CacheMiddleware) IsCacheFresh(
 	return false
 }
 
+func (c *CacheMiddleware) httpClient() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+
+	return &http.Client{
+		Timeout: requestTimeout,
+	}
+}
+
+// isUpstreamUnchanged revalidates the upstream document, and the other
+// documents the entry was built from, and reports whether they are all still
+// the versions the rendered entry was built from, along with the documents fetched.
+func (c *CacheMiddleware) isUpstreamUnchanged(
+	ctx context.Context, upstreamURL string, cacheKey string,
+) (bool, *fetchRecord) {
+	entry := c.storedEntry(cacheKey)
+	if entry.upstreamVersion == "" {
+		return false, nil
+	}
+
+	ctx, fetched := contextWithFetchRecord(ctx)
+
+	doc, err := c.Upstream.Fetch(ctx, upstreamURL)
+	if err != nil {
+		return true, nil
+	}
+
+	if doc.Version != entry.upstreamVersion {
+		return false, fetched
+	}
+
+	for dependencyURL, version := range entry.dependencies {
+		// Unreachable dependencies keep the entry, as an unreachable upstream does.
+		dependency, err := c.Upstream.Fetch(ctx, dependencyURL)
+		if err == nil && dependency.Version != version {
+			return false, fetched
+		}
+	}
+
+	return true, fetched
+}
+
+func (c *CacheMiddleware) storedEntry(cacheKey string) cacheEntry {
+	c.entryMutex.RLock()
+	defer c.entryMutex.RUnlock()
+
+	return c.entries[cacheKey]
+}
+
+func (c *CacheMiddleware) storeEntry(cacheKey string, entry cacheEntry) {
+	c.entryMutex.Lock()
+	defer c.entryMutex.Unlock()
+
+	c.entries[cacheKey] = entry
+}
+
+func (c *CacheMiddleware) removeEntry(cacheKey string) {
+	c.entryMutex.Lock()
+	defer c.entryMutex.Unlock()
+
+	delete(c.entries, cacheKey)
+}
+
 func (c *CacheMiddleware) GetStoredETag(cacheKey string) string {
 	c.etagMutex.RLock()
 	defer c.etagMutex.RUnlock()
@@ -231,11 +432,7 @@ func (r *ResponseRecorder) captureAndStoreETag(ctx context.Context, upstreamURL
 		return
 	}
 
-	client := &http.Client{
-		Timeout: requestTimeout,
-	}
-
-	resp, err := client.Do(req) // #nosec G704
+	resp, err := r.cacheMiddleware.httpClient().Do(req) // #nosec G704
 	if err != nil {
 		return
 	}
@@ -0,0 +1,383 @@
+package ff
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCacheEntryNotFound = errors.New("cache entry not found")
+	ErrRefreshFailed      = errors.New("cache refresh failed")
+	ErrInvalidCacheKey    = errors.New("invalid cache key")
+)
+
+// ValidCacheKey reports whether key has the form GetCacheKey produces: a
+// hex SHA-256 followed by ".rss".
+func ValidCacheKey(key string) bool {
+	digest, ok := strings.CutSuffix(key, ".rss")
+	if !ok || len(digest) != 2*sha256.Size || strings.ToLower(digest) != digest {
+		return false
+	}
+
+	_, err := hex.DecodeString(digest)
+
+	return err == nil
+}
+
+// CacheEntryInfo describes a rendered cache entry for administration.
+type CacheEntryInfo struct {
+	Key          string    `json:"key"`
+	Query        string    `json:"query"`
+	UpstreamURL  string    `json:"upstreamUrl"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"modTime"`
+	AgeSeconds   int64     `json:"ageSeconds"`
+	ETag         string    `json:"etag"`
+	UpstreamETag string    `json:"upstreamEtag"`
+}
+
+// Entries lists the rendered cache files. Files left over from a previous
+// process are listed too, without the bookkeeping that was lost with it.
+func (c *CacheMiddleware) Entries() ([]CacheEntryInfo, error) {
+	matches, err := filepath.Glob(filepath.Join(c.TmpDir, "*.rss"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cache directory: %w", err)
+	}
+
+	infos := make([]CacheEntryInfo, 0, len(matches))
+	now := time.Now()
+
+	for _, path := range matches {
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		key := filepath.Base(path)
+		entry := c.storedEntry(key)
+		infos = append(infos, CacheEntryInfo{
+			Key:          key,
+			Query:        entry.query,
+			UpstreamURL:  entry.upstreamURL,
+			Size:         stat.Size(),
+			ModTime:      stat.ModTime(),
+			AgeSeconds:   int64(now.Sub(stat.ModTime()).Seconds()),
+			ETag:         entry.etag,
+			UpstreamETag: c.upstreamETag(key, entry),
+		})
+	}
+
+	return infos, nil
+}
+
+// upstreamETag is the ETag of the upstream document an entry was built from.
+func (c *CacheMiddleware) upstreamETag(cacheKey string, entry cacheEntry) string {
+	if c.Upstream == nil {
+		return c.GetStoredETag(cacheKey)
+	}
+
+	if upstreamEntry := c.Upstream.lookup(entry.upstreamURL); upstreamEntry != nil {
+		return upstreamEntry.etag
+	}
+
+	return ""
+}
+
+// Purge removes one rendered cache entry and reports whether its file
+// existed. Keys not of the form GetCacheKey produces are ignored.
+func (c *CacheMiddleware) Purge(cacheKey string) bool {
+	removed := c.remove(cacheKey)
+	if removed {
+		c.Metrics.cacheOutcome(CacheEvict)
+	}
+
+	return removed
+}
+
+// remove is Purge without counting an eviction, for entries about to be
+// rendered again.
+func (c *CacheMiddleware) remove(cacheKey string) bool {
+	if !ValidCacheKey(cacheKey) {
+		return false
+	}
+
+	removed := os.Remove(filepath.Join(c.TmpDir, cacheKey)) == nil
+
+	c.RemoveETag(cacheKey)
+	c.removeEntry(cacheKey)
+
+	return removed
+}
+
+// PurgeUpstream removes every rendered entry built from upstreamURL together
+// with the raw upstream document, and returns the number of rendered entries removed.
+func (c *CacheMiddleware) PurgeUpstream(upstreamURL string) int {
+	c.entryMutex.RLock()
+
+	var keys []string
+
+	for key, entry := range c.entries {
+		if NormalizeURL(entry.upstreamURL) == NormalizeURL(upstreamURL) {
+			keys = append(keys, key)
+		}
+	}
+
+	c.entryMutex.RUnlock()
+
+	for _, key := range keys {
+		c.Purge(key)
+	}
+
+	if c.Upstream != nil {
+		c.Upstream.Remove(upstreamURL)
+	}
+
+	return len(keys)
+}
+
+// PurgeAll removes every rendered entry and every raw upstream document.
+func (c *CacheMiddleware) PurgeAll() (int, error) {
+	infos, err := c.Entries()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, info := range infos {
+		c.Purge(info.Key)
+	}
+
+	if c.Upstream != nil {
+		c.Upstream.RemoveAll()
+	}
+
+	return len(infos), nil
+}
+
+// Refresh drops a rendered entry and its upstream document and renders it again.
+func (c *CacheMiddleware) Refresh(ctx context.Context, cacheKey string) error {
+	entry := c.storedEntry(cacheKey)
+	if entry.query == "" {
+		return ErrCacheEntryNotFound
+	}
+
+	if entry.upstreamURL != "" && c.Upstream != nil {
+		c.Upstream.Remove(entry.upstreamURL)
+	}
+
+	c.remove(cacheKey)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/?"+entry.query, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh request: %w", err)
+	}
+
+	w := &discardResponseWriter{header: http.Header{}, statusCode: http.StatusOK}
+	c.ServeHTTP(w, req)
+
+	if w.statusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%w: status %d", ErrRefreshFailed, w.statusCode)
+	}
+
+	return nil
+}
+
+// RefreshInBackground starts Refresh for cacheKey without waiting for it.
+// The refresh keeps the values of ctx, such as its logger, but not its
+// cancellation; Wait blocks until it has finished.
+func (c *CacheMiddleware) RefreshInBackground(ctx context.Context, cacheKey string) error {
+	if c.storedEntry(cacheKey).query == "" {
+		return ErrCacheEntryNotFound
+	}
+
+	ctx = context.WithoutCancel(ctx)
+
+	c.refreshes.Go(func() {
+		if err := c.Refresh(ctx, cacheKey); err != nil {
+			Logger(ctx).ErrorContext(ctx, "background refresh failed", "key", cacheKey, "error", err)
+		}
+	})
+
+	return nil
+}
+
+// Wait blocks until the background refreshes have finished or ctx is done.
+func (c *CacheMiddleware) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		c.refreshes.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background refreshes unfinished: %w", ctx.Err())
+	}
+}
+
+// discardResponseWriter lets the middleware render into the cache without a client.
+type discardResponseWriter struct {
+	header     http.Header
+	statusCode int
+}
+
+func (d *discardResponseWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (d *discardResponseWriter) WriteHeader(statusCode int) {
+	d.statusCode = statusCode
+}
+
+// CacheAdmin serves the cache administration endpoints, authenticated with a
+// bearer token:
+//
+//	GET    /admin/cache                list entries
+//	DELETE /admin/cache?key=K          purge one entry
+//	DELETE /admin/cache?url=U          purge every entry of an upstream
+//	DELETE /admin/cache?all=true       purge everything
+//	POST   /admin/cache/refresh?key=K  force a refresh
+//	POST   /admin/cache/refresh?key=K&async=true  start a refresh in the background
+type CacheAdmin struct {
+	Cache *CacheMiddleware
+	Token string
+	mux   *http.ServeMux
+}
+
+func NewCacheAdmin(cache *CacheMiddleware, token string) *CacheAdmin {
+	a := &CacheAdmin{
+		Cache: cache,
+		Token: token,
+		mux:   http.NewServeMux(),
+	}
+
+	a.mux.HandleFunc("GET /admin/cache", a.list)
+	a.mux.HandleFunc("DELETE /admin/cache", a.purge)
+	a.mux.HandleFunc("POST /admin/cache/refresh", a.refresh)
+
+	return a
+}
+
+func (a *CacheAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ff-admin"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *CacheAdmin) authorized(r *http.Request) bool {
+	if a.Token == "" {
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+func (a *CacheAdmin) list(w http.ResponseWriter, _ *http.Request) {
+	infos, err := a.Cache.Entries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (a *CacheAdmin) purge(w http.ResponseWriter, r *http.Request) {
+	queries := r.URL.Query()
+
+	switch {
+	case queries.Has("key"):
+		key := queries.Get("key")
+		if !ValidCacheKey(key) {
+			http.Error(w, fmt.Sprintf("%s: %q", ErrInvalidCacheKey, key), http.StatusBadRequest)
+
+			return
+		}
+
+		if !a.Cache.Purge(key) {
+			http.Error(w, ErrCacheEntryNotFound.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]int{"purged": 1})
+	case queries.Has("url"):
+		writeJSON(w, http.StatusOK, map[string]int{"purged": a.Cache.PurgeUpstream(queries.Get("url"))})
+	case queries.Get("all") == "true":
+		purged, err := a.Cache.PurgeAll()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+	default:
+		http.Error(w, "must set key, url or all=true", http.StatusBadRequest)
+	}
+}
+
+func (a *CacheAdmin) refresh(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+
+	if r.URL.Query().Get("async") == "true" {
+		if err := a.Cache.RefreshInBackground(r.Context(), key); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, map[string]string{"refreshing": key})
+
+		return
+	}
+
+	err := a.Cache.Refresh(r.Context(), key)
+	if errors.Is(err, ErrCacheEntryNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"refreshed": key})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,221 @@
+package ff_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+const testAdminToken = "secret"
+
+func newAdminTestCache(t *testing.T, renders *atomic.Int32) *ff.CacheMiddleware {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	upstream, err := ff.NewUpstreamCacheWithDir(filepath.Join(dir, "upstream"))
+	assert.NilError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		renders.Add(1)
+		_, _ = w.Write([]byte("admin test"))
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(handler, dir)
+	assert.NilError(t, err)
+
+	middleware.Upstream = upstream
+
+	return middleware
+}
+
+func adminRequest(t *testing.T, handler http.Handler, method, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequestWithContext(context.Background(), method, target, nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestCacheAdminUnauthorized(t *testing.T) {
+	t.Parallel()
+
+	var renders atomic.Int32
+
+	admin := ff.NewCacheAdmin(newAdminTestCache(t, &renders), testAdminToken)
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/admin/cache", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+}
+
+//nolint:funlen
+func TestCacheAdmin(t *testing.T) {
+	t.Parallel()
+
+	var renders atomic.Int32
+
+	middleware := newAdminTestCache(t, &renders)
+	admin := ff.NewCacheAdmin(middleware, testAdminToken)
+
+	for _, upstreamURL := range []string{"https://example.com/a", "https://example.com/b"} {
+		params := url.Values{}
+		params.Set("url", upstreamURL)
+
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil)
+		middleware.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rec := adminRequest(t, admin, http.MethodGet, "/admin/cache")
+	assert.Equal(t, rec.Code, http.StatusOK)
+
+	var infos []ff.CacheEntryInfo
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &infos))
+	assert.Equal(t, len(infos), 2)
+
+	for _, info := range infos {
+		assert.Assert(t, info.UpstreamURL != "")
+		assert.Assert(t, info.ETag != "")
+		assert.Equal(t, info.Size, int64(len("admin test")))
+	}
+
+	key := infos[0].Key
+	rec = adminRequest(t, admin, http.MethodPost, "/admin/cache/refresh?key="+key)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, renders.Load(), int32(3), "refresh should render the entry again")
+
+	rec = adminRequest(t, admin, http.MethodPost, "/admin/cache/refresh?key=missing.rss")
+	assert.Equal(t, rec.Code, http.StatusNotFound)
+
+	rec = adminRequest(t, admin, http.MethodDelete, "/admin/cache?url="+url.QueryEscape("https://example.com/a"))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Body.String(), "{\"purged\":1}\n")
+
+	rec = adminRequest(t, admin, http.MethodDelete, "/admin/cache")
+	assert.Equal(t, rec.Code, http.StatusBadRequest)
+
+	rec = adminRequest(t, admin, http.MethodDelete, "/admin/cache?all=true")
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Body.String(), "{\"purged\":1}\n")
+
+	infos, err := middleware.Entries()
+	assert.NilError(t, err)
+	assert.Equal(t, len(infos), 0)
+}
+
+func TestCacheAdminRefreshInBackground(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+
+	var renders atomic.Int32
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if renders.Add(1) > 1 {
+			<-release
+		}
+
+		_, _ = w.Write([]byte("admin test"))
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(handler, t.TempDir())
+	assert.NilError(t, err)
+
+	admin := ff.NewCacheAdmin(middleware, testAdminToken)
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?url=https://example.com/a", nil)
+	middleware.ServeHTTP(httptest.NewRecorder(), req)
+
+	key := middleware.GetCacheKey(req.URL.Query())
+
+	rec := adminRequest(t, admin, http.MethodPost, "/admin/cache/refresh?async=true&key="+key)
+	assert.Equal(t, rec.Code, http.StatusAccepted)
+
+	rec = adminRequest(t, admin, http.MethodPost, "/admin/cache/refresh?async=true&key=missing.rss")
+	assert.Equal(t, rec.Code, http.StatusNotFound)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, middleware.Wait(ctx), context.DeadlineExceeded, "the refresh is still running")
+
+	close(release)
+	assert.NilError(t, middleware.Wait(context.Background()))
+	assert.Equal(t, renders.Load(), int32(2))
+}
+
+func TestCacheAdminPurgeKey(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("upstream body"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	upstream, err := ff.NewUpstreamCacheWithDir(filepath.Join(dir, "upstream"))
+	assert.NilError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := upstream.Fetch(r.Context(), r.URL.Query().Get("url"))
+		assert.NilError(t, err)
+
+		_, _ = w.Write(doc.Body)
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(handler, dir)
+	assert.NilError(t, err)
+
+	middleware.Upstream = upstream
+	admin := ff.NewCacheAdmin(middleware, testAdminToken)
+
+	params := url.Values{}
+	params.Set("url", server.URL)
+	middleware.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil))
+
+	infos, err := middleware.Entries()
+	assert.NilError(t, err)
+	assert.Equal(t, len(infos), 1)
+	assert.Equal(t, infos[0].UpstreamETag, `"v1"`, "the ETag comes from the upstream cache")
+
+	for _, key := range []string{"", ".", "../upstream", strings.ToUpper(infos[0].Key)} {
+		rec := adminRequest(t, admin, http.MethodDelete, "/admin/cache?key="+url.QueryEscape(key))
+		assert.Equal(t, rec.Code, http.StatusBadRequest, "key %q", key)
+	}
+
+	_, err = os.Stat(dir)
+	assert.NilError(t, err, "the cache directory must survive invalid keys")
+
+	unknown := middleware.GetCacheKey(url.Values{"url": {"https://example.com/unknown"}})
+	rec := adminRequest(t, admin, http.MethodDelete, "/admin/cache?key="+unknown)
+	assert.Equal(t, rec.Code, http.StatusNotFound)
+
+	rec = adminRequest(t, admin, http.MethodDelete, "/admin/cache?key="+infos[0].Key)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Body.String(), "{\"purged\":1}\n")
+
+	rec = adminRequest(t, admin, http.MethodDelete, "/admin/cache?key="+infos[0].Key)
+	assert.Equal(t, rec.Code, http.StatusNotFound, "a purged entry is gone")
+}
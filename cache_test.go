@@ -2,11 +2,13 @@ package ff_test
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -312,3 +314,289 @@ func TestCacheMiddlewareErrorHandling(t *testing.T) {
 	assert.Equal(t, w.Code, http.StatusInternalServerError,
 		"Should return 500 for empty response body")
 }
+
+func TestCacheMiddlewareSharesUpstreamFetch(t *testing.T) {
+	t.Parallel()
+
+	var fullFetches atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		fullFetches.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("upstream body"))
+	}))
+	defer server.Close()
+
+	upstream, err := ff.NewUpstreamCache()
+	assert.NilError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := upstream.Fetch(r.Context(), r.URL.Query().Get("url"))
+		assert.NilError(t, err)
+
+		_, _ = w.Write(doc.Body)
+	})
+
+	middleware, err := ff.NewCacheMiddleware(handler)
+	assert.NilError(t, err)
+
+	middleware.Upstream = upstream
+
+	for _, title := range []string{"a", "b", "a"} {
+		params := url.Values{}
+		params.Set("url", server.URL)
+		params.Set("title.contains", title)
+
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil)
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, http.StatusOK)
+		assert.Equal(t, w.Body.String(), "upstream body")
+
+		t.Cleanup(func() {
+			os.Remove(filepath.Join(middleware.TmpDir, middleware.GetCacheKey(params)))
+		})
+	}
+
+	assert.Equal(t, fullFetches.Load(), int32(1), "filter variations should reuse one upstream fetch")
+}
+
+func TestCacheMiddlewareRegeneratesFromCheckedDocument(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	// Without validators every check downloads the whole body, which changes each time.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "version %d", requests.Add(1))
+	}))
+	defer server.Close()
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := upstream.Fetch(r.Context(), r.URL.Query().Get("url"))
+		assert.NilError(t, err)
+
+		_, _ = w.Write(doc.Body)
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(handler, t.TempDir())
+	assert.NilError(t, err)
+
+	middleware.Upstream = upstream
+
+	params := url.Values{}
+	params.Set("url", server.URL)
+
+	for _, expect := range []string{"version 1", "version 2"} {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil)
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Body.String(), expect)
+	}
+
+	assert.Equal(t, requests.Load(), int32(2), "the changed document found by the check should be rendered")
+}
+
+func TestCacheMiddlewareTagsEntryWithRenderedVersion(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) == 1 {
+			_, _ = w.Write([]byte("old"))
+
+			return
+		}
+
+		_, _ = w.Write([]byte("new"))
+	}))
+	defer server.Close()
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	var raced atomic.Bool
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := upstream.Fetch(r.Context(), r.URL.Query().Get("url"))
+		assert.NilError(t, err)
+
+		// Another request refreshes the shared upstream cache before this render is stored.
+		if raced.CompareAndSwap(false, true) {
+			_, err := upstream.Fetch(context.Background(), r.URL.Query().Get("url"))
+			assert.NilError(t, err)
+		}
+
+		_, _ = w.Write(doc.Body)
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(handler, t.TempDir())
+	assert.NilError(t, err)
+
+	middleware.Upstream = upstream
+
+	params := url.Values{}
+	params.Set("url", server.URL)
+
+	for _, expect := range []string{"old", "new"} {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil)
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Body.String(), expect, "the old render must not pass for the new version")
+	}
+}
+
+func TestCacheMiddlewareConditionalRequest(t *testing.T) {
+	t.Parallel()
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("conditional response"))
+	})
+
+	middleware, err := ff.NewCacheMiddleware(testHandler)
+	assert.NilError(t, err)
+
+	params := url.Values{}
+	params.Set("title.contains", "conditional")
+
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(middleware.TmpDir, middleware.GetCacheKey(params)))
+	})
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil)
+	w := httptest.NewRecorder()
+
+	middleware.ServeHTTP(w, req)
+
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Assert(t, etag != "", "rendered response should carry an ETag")
+
+	conditional := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil)
+	conditional.Header.Set("If-None-Match", etag)
+
+	w2 := httptest.NewRecorder()
+	middleware.ServeHTTP(w2, conditional)
+
+	assert.Equal(t, w2.Code, http.StatusNotModified)
+	assert.Equal(t, w2.Header().Get("ETag"), etag)
+	assert.Equal(t, w2.Header().Get("Cache-Control"), "max-age=60", "Cache-Control should be replayed from cache")
+}
+
+func TestCacheMiddlewareCacheControlAges(t *testing.T) {
+	t.Parallel()
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=2")
+		_, _ = w.Write([]byte("aging response"))
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(testHandler, t.TempDir())
+	assert.NilError(t, err)
+
+	serve := func() string {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?title.contains=age", nil)
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, http.StatusOK)
+
+		return w.Header().Get("Cache-Control")
+	}
+
+	assert.Equal(t, serve(), "public, max-age=2")
+
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, serve(), "public, max-age=1", "hits should only be cacheable for the remaining time")
+
+	time.Sleep(time.Second)
+	assert.Equal(t, serve(), "public, max-age=0")
+}
+
+func TestGetCacheKeyCanonicalize(t *testing.T) {
+	t.Parallel()
+
+	filtersMap := ff.CreateFiltersMap(nil, nil)
+	modifiersMap := ff.CreateModifierMap()
+	middleware := &ff.CacheMiddleware{
+		Canonicalize: func(queries url.Values) url.Values {
+			return ff.CanonicalQuery(queries, filtersMap, modifiersMap)
+		},
+	}
+
+	params1, err := url.ParseQuery("url=https://example.com/feed&title.contains=b&title.contains=a")
+	assert.NilError(t, err)
+
+	params2, err := url.ParseQuery("title.contains=a&utm_source=reader&title.contains=b&url=https://example.com/feed")
+	assert.NilError(t, err)
+
+	assert.Equal(t, middleware.GetCacheKey(params1), middleware.GetCacheKey(params2),
+		"equivalent queries should share a cache key")
+}
+
+func TestCacheMiddlewareDoesNotCacheErrors(t *testing.T) {
+	t.Parallel()
+
+	errorHandler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("upstream failed"))
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(errorHandler, t.TempDir())
+	assert.NilError(t, err)
+
+	params := url.Values{}
+	params.Set("url", "https://example.com/broken")
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil)
+	w := httptest.NewRecorder()
+
+	middleware.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, http.StatusBadGateway)
+	assert.Equal(t, w.Body.String(), "upstream failed")
+
+	_, err = os.Stat(filepath.Join(middleware.TmpDir, middleware.GetCacheKey(params)))
+	assert.Assert(t, os.IsNotExist(err), "error responses should not be cached")
+}
+
+func TestCacheMiddlewareDoesNotCacheNoStore(t *testing.T) {
+	t.Parallel()
+
+	noStoreHandler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write([]byte("diagnostic feed"))
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(noStoreHandler, t.TempDir())
+	assert.NilError(t, err)
+
+	params := url.Values{}
+	params.Set("url", "https://example.com/broken")
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil)
+	w := httptest.NewRecorder()
+
+	middleware.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Body.String(), "diagnostic feed")
+
+	_, err = os.Stat(filepath.Join(middleware.TmpDir, middleware.GetCacheKey(params)))
+	assert.Assert(t, os.IsNotExist(err), "no-store responses should not be cached")
+}
@@ -0,0 +1,71 @@
+package ff
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// pipelineOptionKeys are the query parameters, besides filters and modifiers,
+// that change the rendered output and therefore belong in a cache key.
+var pipelineOptionKeys = []string{"url", "pipeline"}
+
+// CanonicalQuery reduces a request query to the pipeline it describes, so
+// equivalent requests share one cache entry: unknown parameters (tracking
+// parameters and the like) are dropped, filters and modifiers, which commute,
+// have their repeated values sorted and deduplicated, and the upstream URL is normalised.
+func CanonicalQuery(queries url.Values, filtersMap FilterFuncMap, modifiersMap ModifierFuncMap) url.Values {
+	canonical := url.Values{}
+
+	for key, values := range queries {
+		_, isFilter := filtersMap[key]
+		_, isModifier := modifiersMap[key]
+
+		switch {
+		case key == "url":
+			for _, v := range values {
+				canonical.Add(key, NormalizeURL(v))
+			}
+		case isFilter || isModifier || slices.Contains(pipelineOptionKeys, key):
+			sorted := slices.Clone(values)
+			slices.Sort(sorted)
+			canonical[key] = slices.Compact(sorted)
+		}
+	}
+
+	return canonical
+}
+
+// NormalizeURL returns a canonical spelling of an upstream URL: lower-case
+// scheme and host, no default port, no fragment, "/" for an empty path and
+// sorted query parameters. Unparsable input is returned unchanged, as is a
+// query that cannot be parsed.
+func NormalizeURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return raw
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+
+	if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = u.Hostname()
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	// Queries url.ParseQuery rejects, such as ones with semicolons or bad
+	// escapes, are kept as they are: re-encoding would drop the bad pairs and
+	// let different upstreams share a cache key.
+	if query, err := url.ParseQuery(u.RawQuery); err == nil && u.RawQuery != "" {
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
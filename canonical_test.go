@@ -0,0 +1,91 @@
+package ff_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func TestCanonicalQuery(t *testing.T) {
+	t.Parallel()
+
+	filtersMap := ff.CreateFiltersMap(nil, nil)
+	modifiersMap := ff.CreateModifierMap()
+
+	for _, tt := range []struct {
+		name   string
+		a, b   string
+		expect bool
+	}{
+		{
+			"parameter order",
+			"url=https://example.com/feed&title.contains=a&rm.content",
+			"rm.content&title.contains=a&url=https://example.com/feed",
+			true,
+		},
+		{
+			"repeated value order",
+			"url=https://example.com/feed&title.not_contains=a&title.not_contains=b",
+			"url=https://example.com/feed&title.not_contains=b&title.not_contains=a&title.not_contains=a",
+			true,
+		},
+		{
+			"unknown parameters dropped",
+			"url=https://example.com/feed&utm_source=x&unknown=1",
+			"url=https://example.com/feed",
+			true,
+		},
+		{
+			"upstream url normalised",
+			"url=HTTPS://Example.com:443/feed?b=2%26a=1#top",
+			"url=https://example.com/feed?a=1%26b=2",
+			true,
+		},
+		{
+			"different filter values",
+			"url=https://example.com/feed&title.contains=a",
+			"url=https://example.com/feed&title.contains=b",
+			false,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, err := url.ParseQuery(tt.a)
+			assert.NilError(t, err)
+
+			b, err := url.ParseQuery(tt.b)
+			assert.NilError(t, err)
+
+			equal := ff.CanonicalQuery(a, filtersMap, modifiersMap).Encode() ==
+				ff.CanonicalQuery(b, filtersMap, modifiersMap).Encode()
+			assert.Equal(t, equal, tt.expect)
+		})
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		raw    string
+		expect string
+	}{
+		{"https://example.com", "https://example.com/"},
+		{"HTTP://EXAMPLE.com:80/Feed", "http://example.com/Feed"},
+		{"http://example.com:8080/feed#frag", "http://example.com:8080/feed"},
+		{"https://example.com/feed?z=1&a=2", "https://example.com/feed?a=2&z=1"},
+		{"not a url", "not a url"},
+		{"http://h/feed?id=1;2", "http://h/feed?id=1;2"},
+		{"http://h/feed?tag=go;lang=en", "http://h/feed?tag=go;lang=en"},
+		{"http://h/feed?q=%zz", "http://h/feed?q=%zz"},
+	} {
+		t.Run(tt.raw, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, ff.NormalizeURL(tt.raw), tt.expect)
+		})
+	}
+}
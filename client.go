@@ -0,0 +1,249 @@
+package ff
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const DefaultUserAgent = "ff (+https://github.com/nakatanakatana/ff)"
+
+var ErrInvalidCABundle = errors.New("no certificates found in CA bundle")
+
+// Duration is a time.Duration read from configuration as a string like "30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(time.Duration(d).String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal duration: %w", err)
+	}
+
+	return b, nil
+}
+
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// HostRule adds credentials and headers to requests for matching upstream
+// hosts. Host is either an exact host name or a "*.example.com" wildcard.
+type HostRule struct {
+	Host        string            `json:"host"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Cookies     map[string]string `json:"cookies,omitempty"`
+	BearerToken string            `json:"bearerToken,omitempty"`
+	BasicAuth   *BasicAuth        `json:"basicAuth,omitempty"`
+}
+
+func (h HostRule) Matches(host string) bool {
+	return matchHost(h.Host, host)
+}
+
+// ClientConfig configures the HTTP client used for every upstream request.
+type ClientConfig struct {
+	UserAgent string `json:"userAgent,omitempty"`
+	// Proxy is the URL of an egress proxy; HTTP_PROXY and friends are used when empty.
+	Proxy string `json:"proxy,omitempty"`
+	// CABundle is a PEM file of extra certificate authorities to trust.
+	CABundle string     `json:"caBundle,omitempty"`
+	Timeout  Duration   `json:"timeout,omitempty"`
+	Hosts    []HostRule `json:"hosts,omitempty"`
+	// Access, when set, guards every connection against SSRF (see AccessConfig).
+	Access *AccessConfig `json:"access,omitempty"`
+}
+
+// NewUpstreamClient builds the HTTP client shared by feed fetches and cache
+// freshness checks.
+func NewUpstreamClient(config ClientConfig) (*http.Client, error) {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		transport = &http.Transport{}
+	}
+
+	transport = transport.Clone()
+
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.Access != nil {
+		transport.DialContext = config.Access.guardedDialContext()
+	}
+
+	if config.CABundle != "" {
+		pool, err := loadCABundle(config.CABundle)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	timeout := time.Duration(config.Timeout)
+	if timeout <= 0 {
+		timeout = requestTimeout
+	}
+
+	userAgent := config.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &upstreamTransport{
+			base:      transport,
+			userAgent: userAgent,
+			hosts:     config.Hosts,
+			access:    config.Access,
+			proxy:     transport.Proxy,
+		},
+	}, nil
+}
+
+// canonicalAddr returns the host:port a connection to u dials.
+func canonicalAddr(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+
+	switch u.Scheme {
+	case "https":
+		return net.JoinHostPort(u.Hostname(), "443")
+	case "socks5", "socks5h":
+		return net.JoinHostPort(u.Hostname(), "1080")
+	}
+
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	pem, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCABundle, path)
+	}
+
+	return pool, nil
+}
+
+// upstreamTransport applies the access lists, the user agent and the matching
+// host rules. Redirects go through RoundTrip again, so every hop is checked.
+type upstreamTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	hosts     []HostRule
+	access    *AccessConfig
+	proxy     func(*http.Request) (*url.URL, error)
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.access != nil {
+		if err := t.access.CheckURL(req.URL); err != nil {
+			return nil, err
+		}
+
+		ctx, err := t.checkProxied(req)
+		if err != nil {
+			return nil, err
+		}
+
+		req = req.WithContext(ctx)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+
+	for _, rule := range t.hosts {
+		if !rule.Matches(req.URL.Hostname()) {
+			continue
+		}
+
+		for name, value := range rule.Headers {
+			req.Header.Set(name, value)
+		}
+
+		for name, value := range rule.Cookies {
+			req.AddCookie(&http.Cookie{Name: name, Value: value})
+		}
+
+		if rule.BearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+rule.BearerToken)
+		}
+
+		if rule.BasicAuth != nil {
+			req.SetBasicAuth(rule.BasicAuth.Username, rule.BasicAuth.Password)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream round trip failed: %w", err)
+	}
+
+	return resp, nil
+}
+
+// checkProxied checks the target of a request sent through a proxy, since the
+// connection is then made to the proxy and never to the target. The returned
+// context lets the dialer reach the proxy itself.
+func (t *upstreamTransport) checkProxied(req *http.Request) (context.Context, error) {
+	ctx := req.Context()
+	if t.proxy == nil {
+		return ctx, nil
+	}
+
+	proxyURL, err := t.proxy(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select proxy: %w", err)
+	}
+
+	if proxyURL == nil {
+		return ctx, nil
+	}
+
+	if err := t.access.CheckHost(ctx, req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(ctx, proxyAddrKey{}, canonicalAddr(proxyURL)), nil
+}
@@ -0,0 +1,127 @@
+package ff_test
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func TestHostRuleMatches(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		pattern string
+		host    string
+		expect  bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+	} {
+		t.Run(tt.pattern+"/"+tt.host, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, ff.HostRule{Host: tt.pattern}.Matches(tt.host), tt.expect)
+		})
+	}
+}
+
+func TestUpstreamClientHeaders(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer server.Close()
+
+	client, err := ff.NewUpstreamClient(ff.ClientConfig{
+		UserAgent: "test-agent",
+		Hosts: []ff.HostRule{
+			{
+				Host:        "127.0.0.1",
+				Headers:     map[string]string{"X-Api-Version": "2"},
+				Cookies:     map[string]string{"session": "abc"},
+				BearerToken: "token",
+			},
+			{Host: "other.example.com", Headers: map[string]string{"X-Other": "1"}},
+		},
+	})
+	assert.NilError(t, err)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	assert.NilError(t, err)
+
+	resp, err := client.Do(req)
+	assert.NilError(t, err)
+	resp.Body.Close()
+
+	r := <-received
+	assert.Equal(t, r.Header.Get("User-Agent"), "test-agent")
+	assert.Equal(t, r.Header.Get("X-Api-Version"), "2")
+	assert.Equal(t, r.Header.Get("Authorization"), "Bearer token")
+	assert.Equal(t, r.Header.Get("Cookie"), "session=abc")
+	assert.Equal(t, r.Header.Get("X-Other"), "")
+	assert.Equal(t, req.Header.Get("Authorization"), "", "the caller's request should not be modified")
+}
+
+func TestUpstreamClientProxy(t *testing.T) {
+	t.Parallel()
+
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.String()
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	client, err := ff.NewUpstreamClient(ff.ClientConfig{Proxy: proxy.URL})
+	assert.NilError(t, err)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://upstream.invalid/feed", nil)
+	assert.NilError(t, err)
+
+	resp, err := client.Do(req)
+	assert.NilError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, <-proxied, "http://upstream.invalid/feed")
+}
+
+func TestUpstreamClientCABundle(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NilError(t, os.WriteFile(bundle, certPEM, 0o600))
+
+	client, err := ff.NewUpstreamClient(ff.ClientConfig{CABundle: bundle})
+	assert.NilError(t, err)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	assert.NilError(t, err)
+
+	resp, err := client.Do(req)
+	assert.NilError(t, err)
+	resp.Body.Close()
+
+	_, err = ff.NewUpstreamClient(ff.ClientConfig{CABundle: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Assert(t, err != nil && strings.Contains(err.Error(), "CA bundle"))
+}
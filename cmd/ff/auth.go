@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/nakatanakatana/ff"
+)
+
+const (
+	defaultAPIKeyHeader = "X-API-Key"
+	// apiKeyParam carries the API key in the query for feed readers that
+	// cannot send headers. It is removed before the cache key is computed.
+	apiKeyParam = "key"
+)
+
+var (
+	ErrInvalidAuth       = errors.New("invalid auth configuration")
+	ErrMissingCredential = errors.New("missing credentials")
+	ErrBadCredential     = errors.New("invalid credentials")
+	ErrNotAllowed        = errors.New("not allowed for these credentials")
+)
+
+// Allowlist restricts what a credential may request. Empty lists allow everything.
+type Allowlist struct {
+	// Pipelines, when set, are the only pipelines the credential may use;
+	// plain url= requests are refused.
+	Pipelines []string `json:"pipelines,omitempty"`
+	// Hosts, when set, are the only upstream hosts the credential may read,
+	// as host names or "*.example.com" wildcards.
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// APIKey is a static key sent in the API key header or the key= parameter.
+type APIKey struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	Allowlist
+}
+
+// BasicUser is a user authenticated with HTTP basic auth.
+type BasicUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Allowlist
+}
+
+// AuthConfig protects the feed endpoint. Authentication is off while no key
+// and no user is configured.
+type AuthConfig struct {
+	// Header carries API keys and defaults to X-API-Key.
+	Header string      `json:"header,omitempty"`
+	Keys   []APIKey    `json:"keys,omitempty"`
+	Users  []BasicUser `json:"users,omitempty"`
+}
+
+type (
+	clientKey    struct{}
+	allowlistKey struct{}
+)
+
+// clientName returns the name of the authenticated client, or "".
+func clientName(ctx context.Context) string {
+	name, _ := ctx.Value(clientKey{}).(string)
+
+	return name
+}
+
+// clientAllowlist returns the allowlist of the authenticated client, empty
+// when authentication is off.
+func clientAllowlist(ctx context.Context) Allowlist {
+	allowlist, _ := ctx.Value(allowlistKey{}).(Allowlist)
+
+	return allowlist
+}
+
+func (a AuthConfig) enabled() bool {
+	return len(a.Keys) > 0 || len(a.Users) > 0
+}
+
+func (a AuthConfig) check() error {
+	names := make(map[string]bool)
+
+	for _, key := range a.Keys {
+		if key.Name == "" || key.Key == "" {
+			return fmt.Errorf("%w: API keys need a name and a key", ErrInvalidAuth)
+		}
+
+		if names[key.Name] {
+			return fmt.Errorf("%w: duplicate name %q", ErrInvalidAuth, key.Name)
+		}
+
+		names[key.Name] = true
+	}
+
+	for _, user := range a.Users {
+		if user.Username == "" || user.Password == "" {
+			return fmt.Errorf("%w: users need a username and a password", ErrInvalidAuth)
+		}
+
+		if names[user.Username] {
+			return fmt.Errorf("%w: duplicate name %q", ErrInvalidAuth, user.Username)
+		}
+
+		names[user.Username] = true
+	}
+
+	return nil
+}
+
+// authenticate returns the name and allowlist of the request's credentials.
+// Every configured secret is compared, in constant time, so the response
+// time does not tell how much of a secret matched.
+func (a AuthConfig) authenticate(r *http.Request) (string, Allowlist, error) {
+	key := a.apiKey(r)
+	username, password, hasBasic := r.BasicAuth()
+
+	var (
+		name      string
+		allowlist Allowlist
+		found     bool
+	)
+
+	switch {
+	case key != "":
+		for _, candidate := range a.Keys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(candidate.Key)) == 1 {
+				name, allowlist, found = candidate.Name, candidate.Allowlist, true
+			}
+		}
+	case hasBasic:
+		for _, candidate := range a.Users {
+			userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(candidate.Username))
+			passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(candidate.Password))
+
+			if userMatch&passwordMatch == 1 {
+				name, allowlist, found = candidate.Username, candidate.Allowlist, true
+			}
+		}
+	default:
+		return "", Allowlist{}, ErrMissingCredential
+	}
+
+	if !found {
+		return "", Allowlist{}, ErrBadCredential
+	}
+
+	return name, allowlist, nil
+}
+
+// apiKey returns the API key sent in the header or the key= parameter, or "".
+func (a AuthConfig) apiKey(r *http.Request) string {
+	header := a.Header
+	if header == "" {
+		header = defaultAPIKeyHeader
+	}
+
+	if key := r.Header.Get(header); key != "" {
+		return key
+	}
+
+	return r.URL.Query().Get(apiKeyParam)
+}
+
+// allows checks a request for the pipeline name, or for upstreamURL when no
+// pipeline is named, against the allowlist.
+func (l Allowlist) allows(name string, upstreamURL string) error {
+	if len(l.Pipelines) > 0 && !slices.Contains(l.Pipelines, name) {
+		if name == "" {
+			return fmt.Errorf("%w: only pipelines may be requested", ErrNotAllowed)
+		}
+
+		return fmt.Errorf("%w: pipeline %q", ErrNotAllowed, name)
+	}
+
+	if len(l.Hosts) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(upstreamURL)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ff.ErrInvalidURL, upstreamURL)
+	}
+
+	if !slices.ContainsFunc(l.Hosts, func(pattern string) bool {
+		return ff.HostRule{Host: pattern}.Matches(u.Hostname())
+	}) {
+		return fmt.Errorf("%w: host %q", ErrNotAllowed, u.Hostname())
+	}
+
+	return nil
+}
+
+// requireCredentials answers requests without valid credentials with 401 and
+// passes the client's name and allowlist on to next in the request context.
+func requireCredentials(next http.Handler, config AuthConfig) http.Handler {
+	if !config.enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, allowlist, err := config.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ff"`)
+			writeError(w, r, &ff.FeedError{Kind: ff.KindUnauthorized, Err: err})
+
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), clientKey{}, name)
+		ctx = context.WithValue(ctx, allowlistKey{}, allowlist)
+		ctx = ff.ContextWithLogger(ctx, ff.Logger(ctx).With("client", name))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireAuth checks the credentials of feed requests and the allowlist they
+// come with, then removes the key= parameter so it takes no part in the
+// cache key. Pipelines are looked up to check the host they read from.
+func requireAuth(next http.Handler, config AuthConfig, pipelines map[string]ff.Pipeline) http.Handler {
+	if !config.enabled() {
+		return next
+	}
+
+	return requireCredentials(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := clientName(r.Context())
+		queries := r.URL.Query()
+		pipelineName := queries.Get("pipeline")
+
+		upstreamURL := queries.Get("url")
+		if pipeline, ok := pipelines[pipelineName]; ok {
+			upstreamURL = pipeline.URL
+		}
+
+		if err := clientAllowlist(r.Context()).allows(pipelineName, upstreamURL); err != nil {
+			writeError(w, r, &ff.FeedError{Kind: ff.KindForbidden, Err: fmt.Errorf("%s: %w", name, err)})
+
+			return
+		}
+
+		queries.Del(apiKeyParam)
+
+		authorized := r.Clone(r.Context())
+		authorized.URL.RawQuery = queries.Encode()
+
+		next.ServeHTTP(w, authorized)
+	}), config)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+//nolint:funlen
+func TestRequireAuth(t *testing.T) {
+	t.Parallel()
+
+	config := AuthConfig{
+		Keys: []APIKey{
+			{Name: "reader", Key: "reader-key"},
+			{Name: "news", Key: "news-key", Allowlist: Allowlist{Pipelines: []string{"news"}}},
+			{Name: "example", Key: "example-key", Allowlist: Allowlist{Hosts: []string{"*.example.com"}}},
+		},
+		Users: []BasicUser{{Username: "alice", Password: "wonderland"}},
+	}
+	pipelines := map[string]ff.Pipeline{
+		"news":  {URL: "https://news.example.com/feed"},
+		"other": {URL: "https://other.example.org/feed"},
+	}
+
+	handler := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", clientName(r.Context()), r.URL.RawQuery)
+	}), config, pipelines)
+
+	for _, tt := range []struct {
+		name   string
+		target string
+		header http.Header
+		basic  []string
+		status int
+		body   string
+	}{
+		{name: "no credentials", target: "/?url=https://a.test/", status: http.StatusUnauthorized},
+		{name: "unknown key", target: "/?url=https://a.test/&key=nope", status: http.StatusUnauthorized},
+		{
+			name: "header key", target: "/?url=https://a.test/",
+			header: http.Header{"X-Api-Key": {"reader-key"}},
+			status: http.StatusOK, body: "reader url=https%3A%2F%2Fa.test%2F",
+		},
+		{
+			name: "query key is stripped", target: "/?url=https://a.test/&key=reader-key",
+			status: http.StatusOK, body: "reader url=https%3A%2F%2Fa.test%2F",
+		},
+		{
+			name: "basic auth", target: "/?url=https://a.test/", basic: []string{"alice", "wonderland"},
+			status: http.StatusOK, body: "alice url=https%3A%2F%2Fa.test%2F",
+		},
+		{
+			name: "wrong password", target: "/?url=https://a.test/", basic: []string{"alice", "rabbit"},
+			status: http.StatusUnauthorized,
+		},
+		{name: "allowed pipeline", target: "/?pipeline=news&key=news-key", status: http.StatusOK, body: "news pipeline=news"},
+		{name: "other pipeline", target: "/?pipeline=other&key=news-key", status: http.StatusForbidden},
+		{name: "url with a pipeline key", target: "/?url=https://a.test/&key=news-key", status: http.StatusForbidden},
+		{
+			name: "allowed host", target: "/?url=https://www.example.com/feed&key=example-key",
+			status: http.StatusOK, body: "example url=https%3A%2F%2Fwww.example.com%2Ffeed",
+		},
+		{name: "other host", target: "/?url=https://example.org/&key=example-key", status: http.StatusForbidden},
+		{name: "host of a pipeline", target: "/?pipeline=other&key=example-key", status: http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, tt.target, nil)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+
+			if tt.basic != nil {
+				req.SetBasicAuth(tt.basic[0], tt.basic[1])
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, w.Code, tt.status, w.Body.String())
+
+			if tt.status == http.StatusUnauthorized {
+				assert.Equal(t, w.Header().Get("WWW-Authenticate"), `Basic realm="ff"`)
+			}
+
+			if tt.body != "" {
+				assert.Equal(t, w.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestRequireAuthDisabled(t *testing.T) {
+	t.Parallel()
+
+	next := http.NotFoundHandler()
+
+	w := httptest.NewRecorder()
+	requireAuth(next, AuthConfig{}, nil).ServeHTTP(w,
+		httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?key=anything", nil))
+
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/gorilla/feeds"
+	"github.com/mmcdole/gofeed"
+	"github.com/nakatanakatana/ff"
+)
+
+const defaultAddr = ":8080"
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrUnknownFormat  = errors.New("unknown output format")
+	ErrInvalidQuery   = errors.New("invalid query")
+)
+
+const usage = `usage: ff <command> [flags] [query...]
+
+commands:
+  serve     run the HTTP server (the default without a command)
+  filter    apply a query to a feed and print the result
+  validate  check a query and the configuration
+  explain   print which filters keep or drop each item
+  opml      import an OPML file into the pipelines, or export them as OPML
+
+Queries are written as in the URL, e.g. title.contains=go rm.description.
+`
+
+// handlerOptions are the query parameters the feed endpoint reads besides the
+// pipeline, with the values they take; nil takes any value.
+var handlerOptions = map[string][]string{
+	"on_error":     {onErrorFeed, onErrorStale},
+	"error_format": {"json", "text"},
+	apiKeyParam:    nil,
+}
+
+// cli runs the subcommands against the filters and modifiers configured from the environment.
+type cli struct {
+	stdin        io.Reader
+	stdout       io.Writer
+	filtersMap   ff.FilterFuncMap
+	modifiersMap ff.ModifierFuncMap
+}
+
+func (c *cli) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.serve(ctx, nil)
+	}
+
+	switch args[0] {
+	case "serve":
+		return c.serve(ctx, args[1:])
+	case "filter":
+		return c.filter(ctx, args[1:])
+	case "validate":
+		return c.validate(args[1:])
+	case "explain":
+		return c.explain(ctx, args[1:])
+	case "opml":
+		return c.opml(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(c.stdout, usage)
+
+		return nil
+	default:
+		fmt.Fprint(c.stdout, usage)
+
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, args[0])
+	}
+}
+
+// flagSet returns the flags of a subcommand, starting with -config.
+func (c *cli) flagSet(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet("ff "+name, flag.ContinueOnError)
+	flags.SetOutput(c.stdout)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILE"), "JSON configuration file")
+
+	return flags, configPath
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	return nil
+}
+
+// serve runs the HTTP server until SIGTERM or SIGINT, or until ctx is
+// canceled, then stops accepting connections and waits up to the shutdown
+// timeout for in-flight requests and background cache refreshes.
+func (c *cli) serve(ctx context.Context, args []string) error {
+	flags, configPath := c.flagSet("serve")
+
+	var settings serverConfig
+	if err := settings.register(flags, os.Getenv); err != nil {
+		return err
+	}
+
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	mux, cache, err := newServeMux(config, c.filtersMap, c.modifiersMap)
+	if err != nil {
+		return err
+	}
+
+	provider, err := newTracerProvider(ctx, config.Tracing)
+	if err != nil {
+		return err
+	}
+
+	var handler http.Handler = mux
+	if provider != nil {
+		defer provider.Shutdown(context.WithoutCancel(ctx)) //nolint:errcheck
+
+		handler = ff.TraceRequests(provider, mux)
+	}
+
+	server, err := settings.httpServer(ff.LogRequests(nil, handler, apiKeyParam))
+	if err != nil {
+		return err
+	}
+
+	listener, err := settings.listen(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	served := make(chan error, 1)
+
+	go func() {
+		served <- serve(server, listener)
+	}()
+
+	slog.InfoContext(ctx, "listening", "addr", settings.Addr)
+
+	select {
+	case err := <-served:
+		return fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	stop()
+	slog.InfoContext(ctx, "shutting down", "timeout", settings.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), settings.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to drain requests: %w", err)
+	}
+
+	if err := cache.Wait(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to drain the cache: %w", err)
+	}
+
+	return nil
+}
+
+// filter reads a feed, runs the pipeline given as query arguments and writes
+// the result to stdout, without starting a server.
+func (c *cli) filter(ctx context.Context, args []string) error {
+	flags, configPath := c.flagSet("filter")
+	input := flags.String("in", "-", "feed file or URL to read, - for stdin")
+	format := flags.String("format", "rss", "output format: rss, atom or json")
+
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	originFeed, queries, err := c.readFeed(ctx, *configPath, *input, flags.Args())
+	if err != nil {
+		return err
+	}
+
+	filters, modifiers := parseQueries(queries, c.filtersMap, c.modifiersMap)
+
+	filteredFeed, err := ff.Apply(ctx, originFeed, filters, modifiers)
+	if err != nil {
+		return fmt.Errorf("failed to apply filters: %w", err)
+	}
+
+	out, err := renderFeed(ff.Convert(filteredFeed), *format)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, out)
+
+	return nil
+}
+
+// explain prints, for every item, whether it is kept and the verdict of each filter.
+func (c *cli) explain(ctx context.Context, args []string) error {
+	flags, configPath := c.flagSet("explain")
+	input := flags.String("in", "-", "feed file or URL to read, - for stdin")
+
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	originFeed, queries, err := c.readFeed(ctx, *configPath, *input, flags.Args())
+	if err != nil {
+		return err
+	}
+
+	if latestOnlyFlag {
+		queries.Add("published_at.latest", "")
+		queries.Add("updated_at.latest", "")
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0) //nolint:mnd
+
+	for _, decision := range ff.Explain(ctx, originFeed, queries, c.filtersMap) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", verdict(decision.Kept), decision.Item.Title, decision.Item.Link)
+
+		for _, filter := range decision.Decisions {
+			fmt.Fprintf(tw, "  %s\t%s=%s\t\n", verdict(filter.Kept), filter.Key, filter.Value)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("failed to write explanation: %w", err)
+	}
+
+	return nil
+}
+
+func verdict(kept bool) string {
+	if kept {
+		return "keep"
+	}
+
+	return "drop"
+}
+
+// validate checks the configuration file and the query arguments, printing
+// every problem found.
+func (c *cli) validate(args []string) error {
+	flags, configPath := c.flagSet("validate")
+
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := ff.NewUpstreamClient(config.Upstream); err != nil {
+		return fmt.Errorf("invalid upstream config: %w", err)
+	}
+
+	queries, err := queryArgs(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	options := slices.Sorted(maps.Keys(handlerOptions))
+	problems := ff.ValidateQuery(queries, c.filtersMap, c.modifiersMap, options...)
+
+	for _, name := range slices.Sorted(maps.Keys(config.Pipelines)) {
+		pipelineQueries, _ := url.ParseQuery(config.Pipelines[name].Query)
+
+		for _, problem := range ff.ValidateQuery(pipelineQueries, c.filtersMap, c.modifiersMap, options...) {
+			problems = append(problems, fmt.Errorf("pipeline %s: %w", name, problem))
+		}
+	}
+
+	for _, key := range options {
+		allowed := handlerOptions[key]
+		if allowed == nil {
+			continue
+		}
+
+		for _, value := range queries[key] {
+			if !slices.Contains(allowed, value) {
+				problems = append(problems,
+					fmt.Errorf("%w: %s: want one of %s", ff.ErrInvalidParam, key, strings.Join(allowed, ", ")))
+			}
+		}
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(c.stdout, problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %d problems", ErrInvalidQuery, len(problems))
+	}
+
+	fmt.Fprintln(c.stdout, "ok")
+
+	return nil
+}
+
+// readFeed parses the feed named by input, a file, a URL or "-" for stdin,
+// and the query arguments of a subcommand.
+func (c *cli) readFeed(
+	ctx context.Context, configPath string, input string, args []string,
+) (*gofeed.Feed, url.Values, error) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	queries, err := queryArgs(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := c.readInput(ctx, config, input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	feed, err := config.Limits.ParseFeed(ctx, body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", input, err)
+	}
+
+	return feed, queries, nil
+}
+
+// readInput reads stdin for "-", fetches URLs through a throwaway upstream
+// cache configured like the server's, and reads anything else as a local file.
+func (c *cli) readInput(ctx context.Context, config *Config, input string) ([]byte, error) {
+	switch {
+	case input == "-":
+		b, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+
+		return b, nil
+	case strings.Contains(input, "://"):
+		dir, err := os.MkdirTemp("", "ff-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create upstream cache directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+
+		upstream, err := newUpstream(config, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err := upstream.Fetch(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", input, err)
+		}
+
+		return doc.Body, nil
+	default:
+		b, err := os.ReadFile(input) // #nosec G304
+		if err != nil {
+			return nil, fmt.Errorf("failed to read feed: %w", err)
+		}
+
+		return b, nil
+	}
+}
+
+// renderFeed serialises the feed as rss, atom or json.
+func renderFeed(feed *feeds.Feed, format string) (string, error) {
+	var (
+		out string
+		err error
+	)
+
+	switch format {
+	case "", "rss":
+		out, err = feed.ToRss()
+	case "atom":
+		out, err = feed.ToAtom()
+	case "json":
+		out, err = feed.ToJSON()
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", format, err)
+	}
+
+	return out, nil
+}
+
+// queryArgs reads pipeline arguments such as "title.contains=go" or
+// "rm.description&published_at.latest" into the query the HTTP handler would receive.
+func queryArgs(args []string) (url.Values, error) {
+	queries := url.Values{}
+
+	for _, arg := range args {
+		parsed, err := url.ParseQuery(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query %q: %w", arg, err)
+		}
+
+		for key, values := range parsed {
+			queries[key] = append(queries[key], values...)
+		}
+	}
+
+	return queries, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+	"gotest.tools/v3/golden"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8" ?>
+<rss version="2.0">
+<channel>
+  <title>RSS Title</title>
+  <link>http://example.com</link>
+  <description>This is an example RSS feed</description>
+  <item>
+    <title>Example entry</title>
+    <link>http://example.com/1</link>
+    <description>Example description</description>
+  </item>
+  <item>
+    <title>Second entry</title>
+    <link>http://example.com/2</link>
+    <description>Second description</description>
+  </item>
+</channel>
+</rss>`
+
+func newTestCLI(stdin string) (*cli, *bytes.Buffer) {
+	var stdout bytes.Buffer
+
+	return &cli{
+		stdin:        strings.NewReader(stdin),
+		stdout:       &stdout,
+		filtersMap:   ff.CreateFiltersMap(nil, nil),
+		modifiersMap: ff.CreateModifierMap(),
+	}, &stdout
+}
+
+func runTestCLI(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	c, stdout := newTestCLI(stdin)
+
+	// An empty -config keeps CONFIG_FILE from the environment out of the tests.
+	if len(args) > 0 && args[0] != "help" && args[0] != "opml" {
+		args = append([]string{args[0], "-config="}, args[1:]...)
+	}
+
+	err := c.run(context.Background(), args)
+
+	return stdout.String(), err
+}
+
+func TestCLIFilter(t *testing.T) {
+	t.Parallel()
+
+	out, err := runTestCLI(t, testFeed, "filter", "title.contains=Second")
+	assert.NilError(t, err)
+	golden.Assert(t, out, "filtered-rss-feed")
+
+	path := filepath.Join(t.TempDir(), "feed.xml")
+	assert.NilError(t, os.WriteFile(path, []byte(testFeed), 0o600))
+
+	out, err = runTestCLI(t, "", "filter", "-in", path, "-format", "atom", "title.contains=Second&rm.description")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Contains(out, "<feed"))
+	assert.Assert(t, cmp.Contains(out, "Second entry"))
+	assert.Assert(t, !strings.Contains(out, "Example entry"))
+	assert.Assert(t, !strings.Contains(out, "Second description"))
+
+	out, err = runTestCLI(t, testFeed, "filter", "-format", "json")
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Contains(out, `"title": "Example entry"`))
+}
+
+func TestCLIFilterURL(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer server.Close()
+
+	_, err := runTestCLI(t, "", "filter", "-in", server.URL)
+	assert.Assert(t, errors.Is(err, ff.ErrForbiddenUpstream), "the upstream access rules apply to the CLI as well")
+
+	c, stdout := newTestCLI("")
+
+	configPath := writeTestConfig(t, `{"upstream": {"access": {"allowedNetworks": ["127.0.0.0/8", "::1/128"]}}}`)
+
+	err = c.run(context.Background(), []string{"filter", "-config", configPath, "-in", server.URL, "title.contains=Second"})
+	assert.NilError(t, err)
+	golden.Assert(t, stdout.String(), "filtered-rss-feed")
+}
+
+func TestCLIFilterErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := runTestCLI(t, testFeed, "filter", "-format", "csv")
+	assert.Assert(t, errors.Is(err, ErrUnknownFormat))
+
+	_, err = runTestCLI(t, "not a feed", "filter")
+	assert.Assert(t, errors.Is(err, ff.ErrInvalidFeed))
+
+	_, err = runTestCLI(t, "", "filter", "-in", filepath.Join(t.TempDir(), "missing.xml"))
+	assert.Assert(t, errors.Is(err, os.ErrNotExist))
+
+	_, err = runTestCLI(t, "", "frobnicate")
+	assert.Assert(t, errors.Is(err, ErrUnknownCommand))
+}
+
+func TestCLIValidate(t *testing.T) {
+	t.Parallel()
+
+	out, err := runTestCLI(t, "", "validate", "url=https://example.com/&title.contains=go&on_error=feed")
+	assert.NilError(t, err)
+	assert.Equal(t, out, "ok\n")
+
+	out, err = runTestCLI(t, "", "validate",
+		"titel.contains=go", "updated_at.from=yesterday", "rm.content=all", "on_error=ignore")
+	assert.Assert(t, errors.Is(err, ErrInvalidQuery))
+	assert.Equal(t, strings.Count(out, "\n"), 4, out)
+	assert.Assert(t, cmp.Contains(out, "unknown query parameter: titel.contains"))
+	assert.Assert(t, cmp.Contains(out, "updated_at.from: want an RFC 3339 time"))
+	assert.Assert(t, cmp.Contains(out, `rm.content: takes no value, "all" is ignored`))
+	assert.Assert(t, cmp.Contains(out, "on_error: want one of feed, stale"))
+}
+
+func TestCLIValidateAuthenticatedPipeline(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeTestConfig(t, `{
+		"auth": {"keys": [{"name": "reader", "key": "reader-key", "pipelines": ["go-news"]}]},
+		"pipelines": {"go-news": {"url": "https://example.com/feed.xml", "query": "title.contains=go"}}
+	}`)
+
+	out, err := runTestCLI(t, "", "validate", "-config", configPath,
+		"pipeline=go-news&key=reader-key&on_error=stale&error_format=json")
+	assert.NilError(t, err, out)
+	assert.Equal(t, out, "ok\n")
+}
+
+func TestCLIExplain(t *testing.T) {
+	t.Parallel()
+
+	out, err := runTestCLI(t, testFeed, "explain", "title.contains=Second", "link.contains=example.com")
+	assert.NilError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Equal(t, len(lines), 6, out)
+	assert.Assert(t, strings.HasPrefix(lines[0], "drop") && strings.Contains(lines[0], "Example entry"))
+	assert.Assert(t, cmp.Contains(lines[1], "keep  link.contains=example.com"))
+	assert.Assert(t, cmp.Contains(lines[2], "drop  title.contains=Second"))
+	assert.Assert(t, strings.HasPrefix(lines[3], "keep") && strings.Contains(lines[3], "Second entry"))
+}
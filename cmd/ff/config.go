@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/nakatanakatana/ff"
+)
+
+// Config is read from the JSON file named by CONFIG_FILE.
+type Config struct {
+	Upstream   ff.ClientConfig     `json:"upstream"`
+	Resilience ff.ResilienceConfig `json:"resilience"`
+	Limits     ff.LimitsConfig     `json:"limits"`
+	Files      ff.FileConfig       `json:"files"`
+	Tracing    TracingConfig       `json:"tracing"`
+	Auth       AuthConfig          `json:"auth"`
+	RateLimit  RateLimitConfig     `json:"rateLimit"`
+	// BaseURL is the URL ff is served at, used for the feed URLs of /opml.
+	BaseURL string `json:"baseUrl,omitempty"`
+	// Pipelines are served with pipeline=<name>.
+	Pipelines map[string]ff.Pipeline `json:"pipelines"`
+}
+
+// envReference matches ${NAME}, which is replaced with the environment
+// variable so secrets can stay out of the config file.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func loadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if path == "" {
+		config.setDefaults()
+
+		return config, nil
+	}
+
+	b, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	expanded := envReference.ReplaceAllStringFunc(string(b), func(ref string) string {
+		return os.Getenv(envReference.FindStringSubmatch(ref)[1])
+	})
+
+	decoder := json.NewDecoder(strings.NewReader(expanded))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	if err := config.Auth.check(); err != nil {
+		return nil, err
+	}
+
+	if err := config.RateLimit.check(); err != nil {
+		return nil, err
+	}
+
+	if config.BaseURL != "" {
+		if _, err := parseBaseURL(config.BaseURL); err != nil {
+			return nil, err
+		}
+	}
+
+	for name, pipeline := range config.Pipelines {
+		if err := pipeline.Check(); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", name, err)
+		}
+	}
+
+	config.setDefaults()
+
+	return config, nil
+}
+
+func (c *Config) setDefaults() {
+	// Upstream access is guarded unless the config file says otherwise.
+	if c.Upstream.Access == nil {
+		c.Upstream.Access = &ff.AccessConfig{}
+	}
+}
+
+// upstreamHosts are the upstream hosts the configuration names: those of the
+// pipelines and of the access and credential allowlists.
+func (c *Config) upstreamHosts() []string {
+	hosts := make([]string, 0, len(c.Pipelines))
+
+	for _, pipeline := range c.Pipelines {
+		if u, err := url.Parse(pipeline.URL); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, strings.ToLower(u.Hostname()))
+		}
+	}
+
+	if c.Upstream.Access != nil {
+		hosts = append(hosts, c.Upstream.Access.AllowedHosts...)
+	}
+
+	for _, key := range c.Auth.Keys {
+		hosts = append(hosts, key.Hosts...)
+	}
+
+	for _, user := range c.Auth.Users {
+		hosts = append(hosts, user.Hosts...)
+	}
+
+	slices.Sort(hosts)
+
+	return slices.Compact(hosts)
+}
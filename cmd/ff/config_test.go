@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NilError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+//nolint:paralleltest // t.Setenv cannot be used with t.Parallel
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("FF_TEST_TOKEN", "from-env")
+
+	path := writeTestConfig(t, `{
+  "upstream": {
+    "userAgent": "reader",
+    "timeout": "5s",
+    "hosts": [{"host": "*.example.com", "bearerToken": "${FF_TEST_TOKEN}"}]
+  }
+}`)
+
+	config, err := loadConfig(path)
+	assert.NilError(t, err)
+	assert.Equal(t, config.Upstream.UserAgent, "reader")
+	assert.Equal(t, time.Duration(config.Upstream.Timeout), 5*time.Second)
+	assert.Equal(t, config.Upstream.Hosts[0].BearerToken, "from-env")
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Parallel()
+
+	config, err := loadConfig("")
+	assert.NilError(t, err)
+	assert.Equal(t, config.Upstream.UserAgent, "")
+
+	_, err = loadConfig(writeTestConfig(t, `{"unknown": true}`))
+	assert.ErrorContains(t, err, "unknown field")
+
+	_, err = loadConfig(writeTestConfig(t, `{"upstream": {"timeout": "soon"}}`))
+	assert.ErrorContains(t, err, "invalid duration")
+
+	_, err = loadConfig(writeTestConfig(t, `{"pipelines": {"news": {"url": "https://example.com/", "source": "html"}}}`))
+	assert.ErrorContains(t, err, "pipeline news: invalid pipeline")
+
+	_, err = loadConfig(writeTestConfig(t, `{"auth": {"keys": [{"name": "a", "key": "k"}, {"name": "a", "key": "l"}]}}`))
+	assert.ErrorContains(t, err, `invalid auth configuration: duplicate name "a"`)
+
+	_, err = loadConfig(writeTestConfig(t, `{"baseUrl": "ff.example.com"}`))
+	assert.Assert(t, errors.Is(err, ErrInvalidBaseURL))
+}
+
+func TestConfigUpstreamHosts(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{
+		Upstream: ff.ClientConfig{Access: &ff.AccessConfig{AllowedHosts: []string{"*.example.com"}}},
+		Auth: AuthConfig{
+			Keys:  []APIKey{{Name: "reader", Key: "key", Allowlist: Allowlist{Hosts: []string{"news.example.org"}}}},
+			Users: []BasicUser{{Username: "alice", Password: "secret", Allowlist: Allowlist{Hosts: []string{"*.example.com"}}}},
+		},
+		Pipelines: map[string]ff.Pipeline{
+			"news": {URL: "https://News.example.org/feed"},
+			"blog": {URL: "https://blog.example.net:8443/atom.xml"},
+		},
+	}
+
+	assert.DeepEqual(t, config.upstreamHosts(), []string{"*.example.com", "blog.example.net", "news.example.org"})
+}
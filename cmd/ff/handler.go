@@ -1,14 +1,29 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/gorilla/feeds"
 	"github.com/mmcdole/gofeed"
 	"github.com/nakatanakatana/ff"
 )
 
+const (
+	// onErrorFeed answers failures with a feed holding a single diagnostic item.
+	onErrorFeed = "feed"
+	// onErrorStale answers failures with the last good copy of the feed,
+	// the diagnostic item prepended.
+	onErrorStale = "stale"
+)
+
 var (
 	ErrMustSetURL        = errors.New("must set URL")
 	ErrCannotSetMultiURL = errors.New("cannot set multiple URL")
@@ -26,10 +41,147 @@ func parseAndValidateURL(r *http.Request) (string, error) {
 		return "", ErrCannotSetMultiURL
 	}
 
+	u, err := url.Parse(upstream[0])
+	if err != nil || !u.IsAbs() {
+		return "", fmt.Errorf("%w: %q", ff.ErrInvalidURL, upstream[0])
+	}
+
 	return upstream[0], nil
 }
 
-func createHandler(filtersMap ff.FilterFuncMap, modifiersMap ff.ModifierFuncMap) http.HandlerFunc {
+// errorBody is the error response sent with error_format=json.
+type errorBody struct {
+	Error          string       `json:"error"`
+	Kind           ff.ErrorKind `json:"kind"`
+	Status         int          `json:"status"`
+	UpstreamStatus int          `json:"upstreamStatus,omitempty"`
+}
+
+// writeError answers with the status matching the failure. The upstream's own
+// status, when there is one, is reported in the X-Upstream-Status header.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	feedErr := ff.ClassifyError(err)
+	status := feedErr.HTTPStatus()
+
+	logFailure(r, feedErr, status)
+
+	if feedErr.UpstreamStatus != 0 {
+		w.Header().Set("X-Upstream-Status", strconv.Itoa(feedErr.UpstreamStatus))
+	}
+
+	if r.URL.Query().Get("error_format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+
+		_ = json.NewEncoder(w).Encode(errorBody{
+			Error:          feedErr.Error(),
+			Kind:           feedErr.Kind,
+			Status:         status,
+			UpstreamStatus: feedErr.UpstreamStatus,
+		})
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, feedErr)
+}
+
+// logFailure writes the error log line of a failed request: server side
+// failures are errors, rejected requests are only informational.
+func logFailure(r *http.Request, feedErr *ff.FeedError, status int) {
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+
+	attrs := []slog.Attr{
+		slog.String("error", feedErr.Error()),
+		slog.String("kind", string(feedErr.Kind)),
+		slog.Int("status", status),
+	}
+
+	if feedErr.UpstreamStatus != 0 {
+		attrs = append(attrs, slog.Int("upstream_status", feedErr.UpstreamStatus))
+	}
+
+	ff.Logger(r.Context()).LogAttrs(r.Context(), level, "request failed", attrs...)
+}
+
+// loadFeed fetches the upstream and parses it as the pipeline's source.
+func loadFeed(
+	ctx context.Context, upstream *ff.UpstreamCache, pipeline ff.Pipeline, u string,
+) (*gofeed.Feed, *ff.UpstreamDocument, error) {
+	doc, err := upstream.Fetch(ctx, u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	feed, err := pipeline.Parse(ctx, upstream, doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", u, err)
+	}
+
+	return feed, doc, nil
+}
+
+// fallbackFeed is the feed served in place of a failed upstream with
+// on_error set: the last good cached copy for on_error=stale when there is
+// one, otherwise an empty feed.
+func fallbackFeed(
+	ctx context.Context, upstream *ff.UpstreamCache, pipeline ff.Pipeline, u string, mode string,
+) *gofeed.Feed {
+	if mode == onErrorStale {
+		if doc := upstream.Cached(u); doc != nil {
+			if feed, err := pipeline.Parse(ctx, upstream, doc); err == nil {
+				return feed
+			}
+		}
+	}
+
+	return &gofeed.Feed{Title: "ff: " + u, Link: u}
+}
+
+// expandPipelines rewrites pipeline=<name> into the pipeline's url and query
+// before the request reaches the cache, so named pipelines are cached and
+// revalidated like any other query.
+func expandPipelines(next http.Handler, pipelines map[string]ff.Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries := r.URL.Query()
+
+		name := queries.Get("pipeline")
+		if name == "" {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		pipeline, ok := pipelines[name]
+		if !ok {
+			writeError(w, r, &ff.FeedError{
+				Kind: ff.KindInvalidRequest,
+				Err:  fmt.Errorf("%w: %q", ff.ErrUnknownPipeline, name),
+			})
+
+			return
+		}
+
+		queries.Del("url")
+
+		expanded := r.Clone(r.Context())
+		expanded.URL.RawQuery = pipeline.Queries(queries).Encode()
+
+		next.ServeHTTP(w, expanded)
+	})
+}
+
+// createHandler serves ?url=<feed>&<query>. Requests naming a pipeline are
+// expected to have been expanded by expandPipelines.
+func createHandler(
+	upstream *ff.UpstreamCache, pipelines map[string]ff.Pipeline,
+	filtersMap ff.FilterFuncMap, modifiersMap ff.ModifierFuncMap,
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet && r.Method != http.MethodHead {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -39,42 +191,56 @@ func createHandler(filtersMap ff.FilterFuncMap, modifiersMap ff.ModifierFuncMap)
 
 		u, err := parseAndValidateURL(r)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprint(w, err)
+			writeError(w, r, &ff.FeedError{Kind: ff.KindInvalidRequest, Err: err})
 
 			return
 		}
 
-		fp := gofeed.NewParser()
+		pipeline := pipelines[r.URL.Query().Get("pipeline")]
 
-		originFeed, err := fp.ParseURL(u)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintln(w, fmt.Errorf("ParseURL Error: %w", err))
+		originFeed, doc, failure := loadFeed(r.Context(), upstream, pipeline, u)
+		if failure != nil {
+			mode := r.URL.Query().Get("on_error")
+			if mode != onErrorFeed && mode != onErrorStale {
+				writeError(w, r, failure)
 
-			return
+				return
+			}
+
+			ff.Logger(r.Context()).WarnContext(r.Context(), "serving fallback feed",
+				"url", u, "on_error", mode, "error", failure)
+
+			originFeed = fallbackFeed(r.Context(), upstream, pipeline, u, mode)
 		}
 
 		filters, modifiers := parseQueries(r.URL.Query(), filtersMap, modifiersMap)
 
 		filteredFeed, err := ff.Apply(r.Context(), originFeed, filters, modifiers)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintln(w, err)
+			writeError(w, r, err)
 
 			return
 		}
 
-		c := ff.Convert(filteredFeed)
+		if failure != nil {
+			// The diagnostic item is added after filtering so no filter can hide it.
+			filteredFeed.Items = append([]*gofeed.Item{ff.ErrorItem(u, failure, time.Now())}, filteredFeed.Items...)
+		}
 
+		_, span := ff.StartSpan(r.Context(), "render", ff.AttrItemsAfter.Int(len(filteredFeed.Items)))
+		c := ff.Convert(filteredFeed)
 		rss, err := c.ToRss()
+
+		span.End()
+
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintln(w, err)
+			writeError(w, r, err)
 
 			return
 		}
 
+		setCacheHeaders(w, c, doc, failure)
+
 		w.WriteHeader(http.StatusOK)
 
 		if r.Method == http.MethodHead {
@@ -84,3 +250,23 @@ func createHandler(filtersMap ff.FilterFuncMap, modifiersMap ff.ModifierFuncMap)
 		fmt.Fprintln(w, rss) // #nosec G705
 	}
 }
+
+// setCacheHeaders describes how long the rendered feed may be reused. Feeds
+// reporting a failure are never stored, so the next request retries the upstream.
+func setCacheHeaders(w http.ResponseWriter, c *feeds.Feed, doc *ff.UpstreamDocument, failure error) {
+	if failure != nil {
+		w.Header().Set("Cache-Control", "no-store")
+
+		if status := ff.ClassifyError(failure).UpstreamStatus; status != 0 {
+			w.Header().Set("X-Upstream-Status", strconv.Itoa(status))
+		}
+
+		return
+	}
+
+	w.Header().Set("ETag", ff.FeedETag(c))
+
+	if ttl := time.Until(doc.FreshUntil()).Round(time.Second); ttl > 0 {
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(ttl.Seconds())))
+	}
+}
@@ -2,24 +2,37 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/mmcdole/gofeed"
 	"github.com/nakatanakatana/ff"
 	"gotest.tools/v3/assert"
 	"gotest.tools/v3/assert/cmp"
 	"gotest.tools/v3/golden"
 )
 
+func newTestUpstream(t *testing.T) *ff.UpstreamCache {
+	t.Helper()
+
+	upstream, err := ff.NewUpstreamCache()
+	assert.NilError(t, err)
+
+	return upstream
+}
+
 //nolint:funlen
 func TestHandlerInvalidRequest(t *testing.T) {
 	t.Parallel()
 
 	filtersMap := ff.CreateFiltersMap([]string{}, []string{})
 	modifiersMap := ff.CreateModifierMap()
-	handler := createHandler(filtersMap, modifiersMap)
+	handler := createHandler(newTestUpstream(t), nil, filtersMap, modifiersMap)
 
 	testCases := []struct {
 		name             string
@@ -47,7 +60,16 @@ func TestHandlerInvalidRequest(t *testing.T) {
 			expectedResponse: "cannot set multiple URL",
 		},
 		{
-			name: "Invalid feed URL should return BadRequest",
+			name: "Relative URL is rejected",
+			setupMockServer: func() (*httptest.Server, func()) {
+				return nil, func() {}
+			},
+			requestURL:       "/?url=feed.xml",
+			expectedStatus:   http.StatusBadRequest,
+			expectedResponse: "invalid upstream URL",
+		},
+		{
+			name: "Upstream error status should return BadGateway",
 			setupMockServer: func() (*httptest.Server, func()) {
 				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 					w.WriteHeader(http.StatusNotFound)
@@ -57,11 +79,11 @@ func TestHandlerInvalidRequest(t *testing.T) {
 				return server, server.Close
 			},
 			requestURL:       "/?url=%s",
-			expectedStatus:   http.StatusBadRequest,
+			expectedStatus:   http.StatusBadGateway,
 			expectedResponse: "404",
 		},
 		{
-			name: "Invalid XML feed should return BadRequest",
+			name: "Invalid XML feed should return BadGateway",
 			setupMockServer: func() (*httptest.Server, func()) {
 				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 					w.WriteHeader(http.StatusOK)
@@ -71,8 +93,8 @@ func TestHandlerInvalidRequest(t *testing.T) {
 				return server, server.Close
 			},
 			requestURL:       "/?url=%s",
-			expectedStatus:   http.StatusBadRequest,
-			expectedResponse: "ParseURL Error",
+			expectedStatus:   http.StatusBadGateway,
+			expectedResponse: "did not serve a valid feed",
 		},
 	}
 
@@ -105,7 +127,7 @@ func TestHandlerSuccess(t *testing.T) {
 
 	filtersMap := ff.CreateFiltersMap([]string{}, []string{})
 	modifiersMap := ff.CreateModifierMap()
-	handler := createHandler(filtersMap, modifiersMap)
+	handler := createHandler(newTestUpstream(t), nil, filtersMap, modifiersMap)
 
 	testCases := []struct {
 		name           string
@@ -169,7 +191,7 @@ func TestHandlerSuccess(t *testing.T) {
 func TestHandlerMethodNotAllowed(t *testing.T) {
 	t.Parallel()
 
-	handler := createHandler(ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+	handler := createHandler(newTestUpstream(t), nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
 
 	req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, "/?url=http://example.com", nil)
 	rec := httptest.NewRecorder()
@@ -182,7 +204,7 @@ func TestHandlerMethodNotAllowed(t *testing.T) {
 func TestHandlerHeadRequest(t *testing.T) {
 	t.Parallel()
 
-	handler := createHandler(ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+	handler := createHandler(newTestUpstream(t), nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
 
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -208,7 +230,7 @@ func TestHandlerHeadRequest(t *testing.T) {
 func TestHandlerWithMultipleQueries(t *testing.T) {
 	t.Parallel()
 
-	handler := createHandler(ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+	handler := createHandler(newTestUpstream(t), nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
 
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -234,3 +256,259 @@ func TestHandlerWithMultipleQueries(t *testing.T) {
 	assert.Assert(t, cmp.Contains(rec.Body.String(), "First item"))
 	assert.Assert(t, !strings.Contains(rec.Body.String(), "Second item"))
 }
+
+func TestHandlerCacheHeaders(t *testing.T) {
+	t.Parallel()
+
+	handler := createHandler(newTestUpstream(t), nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8" ?>
+<rss version="2.0">
+<channel>
+  <title>RSS Title</title>
+  <ttl>30</ttl>
+  <item><title>First item</title></item>
+</channel>
+</rss>`))
+	}))
+	defer mockServer.Close()
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?url="+mockServer.URL, nil)
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "max-age=1800", rec.Header().Get("Cache-Control"))
+	assert.Assert(t, strings.HasPrefix(rec.Header().Get("ETag"), `W/"`))
+}
+
+func TestHandlerForbiddenUpstream(t *testing.T) {
+	t.Parallel()
+
+	upstream := newTestUpstream(t)
+
+	client, err := ff.NewUpstreamClient(ff.ClientConfig{Access: &ff.AccessConfig{}})
+	assert.NilError(t, err)
+
+	upstream.Client = client
+	handler := createHandler(upstream, nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+
+	for _, target := range []string{"http://169.254.169.254/latest/meta-data/", "file:///etc/passwd"} {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?url="+target, nil)
+		rec := httptest.NewRecorder()
+
+		handler(rec, req)
+
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Assert(t, cmp.Contains(rec.Body.String(), "forbidden upstream"))
+	}
+}
+
+func TestHandlerUpstreamErrorDetails(t *testing.T) {
+	t.Parallel()
+
+	handler := createHandler(newTestUpstream(t), nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer mockServer.Close()
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet,
+		"/?error_format=json&url="+mockServer.URL, nil)
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "410", rec.Header().Get("X-Upstream-Status"))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var body errorBody
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, ff.KindUpstreamStatus, body.Kind)
+	assert.Equal(t, http.StatusBadGateway, body.Status)
+	assert.Equal(t, http.StatusGone, body.UpstreamStatus)
+}
+
+func TestHandlerUpstreamTimeout(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	mockServer := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		<-release
+	}))
+	defer mockServer.Close()
+	defer close(release)
+
+	upstream := newTestUpstream(t)
+	upstream.Client = &http.Client{Timeout: 10 * time.Millisecond}
+	upstream.Resilience = ff.ResilienceConfig{MaxRetries: -1}
+
+	handler := createHandler(upstream, nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?url="+mockServer.URL, nil)
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+}
+
+func TestHandlerOnErrorFeed(t *testing.T) {
+	t.Parallel()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mockServer.Close()
+
+	upstream := newTestUpstream(t)
+	upstream.Resilience = ff.ResilienceConfig{MaxRetries: -1}
+
+	handler := createHandler(upstream, nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet,
+		"/?on_error=feed&title.contains=never&url="+mockServer.URL, nil)
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	assert.Equal(t, "500", rec.Header().Get("X-Upstream-Status"))
+
+	feed, err := gofeed.NewParser().ParseString(rec.Body.String())
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Len(feed.Items, 1), "the diagnostic item must not be filtered out")
+	assert.Assert(t, cmp.Contains(feed.Items[0].Description, "Upstream status: 500"))
+}
+
+func TestHandlerOnErrorStale(t *testing.T) {
+	t.Parallel()
+
+	var failing atomic.Bool
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/rss+xml")
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8" ?>
+<rss version="2.0"><channel><title>RSS Title</title>
+<item><title>cached item</title><link>https://example.com/1</link></item>
+</channel></rss>`))
+	}))
+	defer mockServer.Close()
+
+	upstream := newTestUpstream(t)
+	upstream.Resilience = ff.ResilienceConfig{MaxRetries: -1}
+
+	handler := createHandler(upstream, nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet,
+			"/?on_error=stale&url="+mockServer.URL, nil)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, get().Code)
+
+	failing.Store(true)
+
+	rec := get()
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	feed, err := gofeed.NewParser().ParseString(rec.Body.String())
+	assert.NilError(t, err)
+	assert.Equal(t, feed.Title, "RSS Title")
+	assert.Assert(t, cmp.Len(feed.Items, 2))
+	assert.Assert(t, cmp.Contains(feed.Items[0].Description, "Upstream status: 502"))
+	assert.Equal(t, feed.Items[1].Title, "cached item")
+}
+
+func TestHandlerLimitExceeded(t *testing.T) {
+	t.Parallel()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8" ?>
+<rss version="2.0"><channel><title>RSS Title</title>
+<item><title>1</title></item><item><title>2</title></item>
+</channel></rss>`))
+	}))
+	defer mockServer.Close()
+
+	upstream := newTestUpstream(t)
+	upstream.Limits = ff.LimitsConfig{MaxItems: 1}
+
+	handler := createHandler(upstream, nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet,
+		"/?error_format=json&url="+mockServer.URL, nil)
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+
+	var body errorBody
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, ff.KindLimitExceeded, body.Kind)
+	assert.Assert(t, cmp.Contains(body.Error, "more than 1"))
+}
+
+func TestHandlerHTMLPipeline(t *testing.T) {
+	t.Parallel()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`<html><head><title>Acme</title></head><body>
+<ul><li><a href="/1">Go release</a></li><li><a href="/2">Rust release</a></li></ul>
+</body></html>`))
+	}))
+	defer mockServer.Close()
+
+	pipelines := map[string]ff.Pipeline{
+		"acme": {
+			URL:    mockServer.URL,
+			Source: ff.SourceHTML,
+			HTML:   &ff.HTMLSource{Item: "li"},
+			Query:  "title.contains=release",
+		},
+	}
+
+	handler := expandPipelines(
+		createHandler(newTestUpstream(t), pipelines, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap()),
+		pipelines,
+	)
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet,
+		"/?pipeline=acme&title.contains=Go&url=https://ignored.example.com/", nil)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	feed, err := gofeed.NewParser().ParseString(rec.Body.String())
+	assert.NilError(t, err)
+	assert.Equal(t, feed.Title, "Acme")
+	assert.Assert(t, cmp.Len(feed.Items, 1))
+	assert.Equal(t, feed.Items[0].Link, mockServer.URL+"/1")
+
+	req = httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?pipeline=missing", nil)
+	rec = httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Assert(t, cmp.Contains(rec.Body.String(), "unknown pipeline"))
+}
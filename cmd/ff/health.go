@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"slices"
+)
+
+var ErrConfigNotLoaded = errors.New("configuration not loaded")
+
+// readinessCheck reports why the server cannot serve feeds, or nil.
+type readinessCheck func() error
+
+// readyBody is the /readyz response: "ok" or the error of every check.
+type readyBody struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// versionBody is the /version response, read from the build information.
+type versionBody struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// healthHandler answers liveness probes: the process is up when it answers.
+func healthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// readyHandler answers readiness probes with 503 while any check fails.
+func readyHandler(checks map[string]readinessCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		body := readyBody{Status: "ok", Checks: make(map[string]string, len(checks))}
+		status := http.StatusOK
+
+		for _, name := range slices.Sorted(maps.Keys(checks)) {
+			body.Checks[name] = "ok"
+
+			if err := checks[name](); err != nil {
+				body.Checks[name] = err.Error()
+				body.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
+
+// configLoaded checks the configuration the process is serving: it was read
+// and its pipelines pass Check. The file on disk is not read again, so an
+// edit nobody has reloaded cannot take healthy servers out of rotation; use
+// ff validate to check edits.
+func configLoaded(config *Config) readinessCheck {
+	return func() error {
+		if config == nil {
+			return ErrConfigNotLoaded
+		}
+
+		for name, pipeline := range config.Pipelines {
+			if err := pipeline.Check(); err != nil {
+				return fmt.Errorf("pipeline %s: %w", name, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// writableDir checks that a file can be created in dir.
+func writableDir(dir string) readinessCheck {
+	return func() error {
+		f, err := os.CreateTemp(dir, "readyz-*")
+		if err != nil {
+			return fmt.Errorf("cannot write to %s: %w", dir, err)
+		}
+
+		f.Close()
+		os.Remove(f.Name())
+
+		return nil
+	}
+}
+
+// versionHandler reports the module version and VCS information the binary
+// was built with.
+func versionHandler() http.Handler {
+	body := versionBody{Version: "unknown"}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		body.Version = info.Main.Version
+		body.GoVersion = info.GoVersion
+
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				body.Revision = setting.Value
+			case "vcs.time":
+				body.Time = setting.Value
+			case "vcs.modified":
+				body.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
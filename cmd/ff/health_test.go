@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func TestProbeRoutes(t *testing.T) {
+	t.Parallel()
+
+	config, err := loadConfig("")
+	assert.NilError(t, err)
+
+	mux, _, err := newServeMux(config, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+	assert.NilError(t, err)
+
+	for _, tt := range []struct {
+		path        string
+		contentType string
+	}{
+		{"/healthz", "text/plain; charset=utf-8"},
+		{"/readyz", "application/json"},
+		{"/version", "application/json"},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequestWithContext(context.Background(), http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, w.Code, http.StatusOK, w.Body.String())
+			assert.Equal(t, w.Header().Get("Content-Type"), tt.contentType)
+		})
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	t.Parallel()
+
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	for _, tt := range []struct {
+		name   string
+		checks map[string]readinessCheck
+		status int
+		body   readyBody
+	}{
+		{
+			"ready",
+			map[string]readinessCheck{"config": configLoaded(&Config{}), "cache": writableDir(t.TempDir())},
+			http.StatusOK,
+			readyBody{Status: "ok", Checks: map[string]string{"config": "ok", "cache": "ok"}},
+		},
+		{
+			"no config",
+			map[string]readinessCheck{"config": configLoaded(nil)},
+			http.StatusServiceUnavailable,
+			readyBody{Status: "unavailable", Checks: map[string]string{"config": "configuration not loaded"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			readyHandler(tt.checks).ServeHTTP(w,
+				httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, w.Code, tt.status)
+
+			var body readyBody
+			assert.NilError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.DeepEqual(t, body, tt.body)
+		})
+	}
+
+	assert.ErrorContains(t, writableDir(missing)(), "cannot write to "+missing)
+}
+
+func TestConfigLoaded(t *testing.T) {
+	t.Parallel()
+
+	path := writeTestConfig(t, `{"pipelines": {"news": {"url": "https://example.com/feed"}}}`)
+
+	config, err := loadConfig(path)
+	assert.NilError(t, err)
+	assert.NilError(t, configLoaded(config)())
+
+	assert.NilError(t, os.WriteFile(path, []byte(`{"pipelines": {"news": {"url": "feed"}}}`), 0o600))
+	assert.NilError(t, configLoaded(config)(), "edits on disk do not affect the running configuration")
+
+	config.Pipelines["broken"] = ff.Pipeline{URL: "feed"}
+	assert.Assert(t, errors.Is(configLoaded(config)(), ff.ErrInvalidPipeline))
+}
+
+func TestVersionHandler(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	versionHandler().ServeHTTP(w, httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/version", nil))
+
+	var body versionBody
+	assert.NilError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Assert(t, body.Version != "")
+	assert.Assert(t, body.GoVersion != "")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+var ErrUnknownLogFormat = errors.New("unknown log format")
+
+// newLogger creates the logger configured by LOG_FORMAT (text or json) and
+// LOG_LEVEL (debug, info, warn or error). Empty values select text and info.
+func newLogger(w io.Writer, format string, level string) (*slog.Logger, error) {
+	var leveler slog.Level
+
+	if level != "" {
+		if err := leveler.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: leveler}
+
+	switch format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownLogFormat, format)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNewLogger(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name   string
+		format string
+		level  string
+		expect string
+		err    string
+	}{
+		{"defaults", "", "", `level=INFO msg=shown`, ""},
+		{"json", "json", "", `"msg":"shown"`, ""},
+		{"debug", "text", "debug", `msg=hidden`, ""},
+		{"unknown format", "xml", "", "", "unknown log format"},
+		{"unknown level", "", "loud", "", "invalid LOG_LEVEL"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+
+			logger, err := newLogger(&buf, tt.format, tt.level)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+
+				return
+			}
+
+			assert.NilError(t, err)
+
+			logger.DebugContext(context.Background(), "hidden")
+			logger.InfoContext(context.Background(), "shown")
+
+			assert.Assert(t, strings.Contains(buf.String(), tt.expect), buf.String())
+		})
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/nakatanakatana/ff"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
@@ -44,25 +51,110 @@ func main() {
 		latestOnlyFlag = true
 	}
 
-	filtersMap := ff.CreateFiltersMap(muteAuthors, muteURLs)
-	modifiersMap := ff.CreateModifierMap()
+	logger, err := newLogger(os.Stderr, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	slog.SetDefault(logger)
+
+	c := &cli{
+		stdin:        os.Stdin,
+		stdout:       os.Stdout,
+		filtersMap:   ff.CreateFiltersMap(muteAuthors, muteURLs),
+		modifiersMap: ff.CreateModifierMap(),
+	}
+
+	if err := c.run(context.Background(), os.Args[1:]); err != nil {
+		logger.Error("ff stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// newUpstream creates the upstream cache in dir, configured from config.
+func newUpstream(config *Config, dir string) (*ff.UpstreamCache, error) {
+	client, err := ff.NewUpstreamClient(config.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upstream client: %w", err)
+	}
+
+	upstream, err := ff.NewUpstreamCacheWithDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upstream cache: %w", err)
+	}
+
+	upstream.Client = client
+	upstream.Resilience = config.Resilience
+	upstream.Limits = config.Limits
+	upstream.Files = config.Files
 
-	handler := createHandler(filtersMap, modifiersMap)
+	return upstream, nil
+}
+
+// newServeMux wires the upstream cache, the feed handler, the rendered cache
+// and the admin endpoints together. The rendered cache is returned as well so
+// its background refreshes can be awaited on shutdown.
+func newServeMux(
+	config *Config, filtersMap ff.FilterFuncMap, modifiersMap ff.ModifierFuncMap,
+) (*http.ServeMux, *ff.CacheMiddleware, error) {
+	upstream, err := newUpstream(config, filepath.Join(os.TempDir(), "ff-cache", "upstream"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	metrics := ff.NewMetrics(registry)
+	metrics.Hosts = config.upstreamHosts()
+	upstream.Metrics = metrics
+
+	handler := createHandler(upstream, config.Pipelines, filtersMap, modifiersMap)
 
 	cacheMiddleware, err := ff.NewCacheMiddleware(handler)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, fmt.Errorf("failed to create cache: %w", err)
 	}
 
+	cacheMiddleware.Upstream = upstream
+	cacheMiddleware.Metrics = metrics
+	cacheMiddleware.Client = upstream.Client
+	cacheMiddleware.Canonicalize = func(queries url.Values) url.Values {
+		return ff.CanonicalQuery(queries, filtersMap, modifiersMap)
+	}
+
+	limiter, err := newRateLimiter(config.RateLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Client IPs are limited before authentication, so failed attempts count;
+	// credentials after it, once it has named the client.
+	feeds := limiter.limitKey(expandPipelines(cacheMiddleware, config.Pipelines))
+	feeds = limiter.limitIP(requireAuth(feeds, config.Auth, config.Pipelines))
+
+	// Metrics and the OPML list name the pipelines and upstreams, so they need
+	// credentials as well; any valid credential may read them.
+	metricsHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	opml := opmlHandler(config.Pipelines, config.Auth, config.BaseURL)
+
 	mux := http.NewServeMux()
-	mux.Handle("/", cacheMiddleware)
+	mux.Handle("/", metrics.Middleware(feeds))
+	mux.Handle("GET /metrics", limiter.limitIP(requireCredentials(metricsHandler, config.Auth)))
+	mux.Handle("GET /opml", limiter.limitIP(requireCredentials(opml, config.Auth)))
+
+	// Probes and build information bypass the cache and the request metrics.
+	mux.Handle("GET /healthz", healthHandler())
+	mux.Handle("GET /readyz", readyHandler(map[string]readinessCheck{
+		"config":         configLoaded(config),
+		"cache":          writableDir(cacheMiddleware.TmpDir),
+		"upstream_cache": writableDir(upstream.Dir),
+	}))
+	mux.Handle("GET /version", versionHandler())
 
-	server := http.Server{
-		Addr:         ":8080",
-		Handler:      mux,
-		ReadTimeout:  HTTPReadTimeout,
-		WriteTimeout: HTTPWriteTimeout,
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		mux.Handle("/admin/", ff.NewCacheAdmin(cacheMiddleware, adminToken))
 	}
 
-	log.Fatal(server.ListenAndServe())
+	return mux, cacheMiddleware, nil
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/nakatanakatana/ff"
+)
+
+const opmlTitle = "ff"
+
+var (
+	ErrInvalidFolderQuery = errors.New("want -folder-query Folder=query")
+	ErrInvalidBaseURL     = errors.New("want an absolute http or https base URL")
+	ErrInvalidHost        = errors.New("invalid Host header")
+)
+
+// folderQueries is the repeatable -folder-query flag.
+type folderQueries map[string]string
+
+func (f folderQueries) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f folderQueries) Set(value string) error {
+	folder, query, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidFolderQuery, value)
+	}
+
+	f[folder] = query
+
+	return nil
+}
+
+// opml imports an OPML file into the pipelines of a configuration or exports
+// the configured pipelines as OPML.
+func (c *cli) opml(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: want opml import or opml export", ErrUnknownCommand)
+	}
+
+	switch args[0] {
+	case "import":
+		return c.opmlImport(args[1:])
+	case "export":
+		return c.opmlExport(args[1:])
+	default:
+		return fmt.Errorf("%w: opml %s", ErrUnknownCommand, args[0])
+	}
+}
+
+// opmlImport prints the configuration file with a pipeline added for every
+// feed of the OPML files. The file is edited as raw JSON, so ${NAME}
+// references are kept rather than expanded.
+func (c *cli) opmlImport(args []string) error {
+	flags, configPath := c.flagSet("opml import")
+	queries := folderQueries{}
+	flags.Var(queries, "folder-query", "default query for the feeds of an outline folder, as Folder=query (repeatable)")
+
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	raw := map[string]json.RawMessage{}
+	pipelines := map[string]ff.Pipeline{}
+
+	if *configPath != "" {
+		b, err := os.ReadFile(*configPath)
+		if err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return fmt.Errorf("failed to parse config %s: %w", *configPath, err)
+		}
+
+		if existing, ok := raw["pipelines"]; ok {
+			if err := json.Unmarshal(existing, &pipelines); err != nil {
+				return fmt.Errorf("failed to parse pipelines of %s: %w", *configPath, err)
+			}
+		}
+	}
+
+	for _, name := range flags.Args() {
+		file, err := os.Open(name) // #nosec G304
+		if err != nil {
+			return fmt.Errorf("failed to read OPML: %w", err)
+		}
+
+		feeds, err := ff.ParseOPML(file)
+		file.Close()
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		ff.ImportOPML(pipelines, feeds, queries)
+	}
+
+	encoded, err := json.Marshal(pipelines)
+	if err != nil {
+		return fmt.Errorf("failed to encode pipelines: %w", err)
+	}
+
+	raw["pipelines"] = encoded
+
+	out, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	fmt.Fprintln(c.stdout, string(out))
+
+	return nil
+}
+
+func (c *cli) opmlExport(args []string) error {
+	flags, configPath := c.flagSet("opml export")
+	baseURL := flags.String("base-url", "http://localhost"+defaultAddr+"/", "URL ff is served at")
+
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	if err := ff.WriteOPML(c.stdout, opmlTitle, *baseURL, config.Pipelines); err != nil {
+		return fmt.Errorf("failed to export OPML: %w", err)
+	}
+
+	return nil
+}
+
+// opmlHandler serves the OPML export of the pipelines the client may request.
+// Feed URLs point at baseURL or, without one, at the host the request was
+// sent to, and carry the client's API key so feed readers can subscribe.
+func opmlHandler(pipelines map[string]ff.Pipeline, auth AuthConfig, baseURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base, err := servedURL(r, baseURL)
+		if err != nil {
+			writeError(w, r, &ff.FeedError{Kind: ff.KindInvalidRequest, Err: err})
+
+			return
+		}
+
+		if key := auth.apiKey(r); auth.enabled() && key != "" {
+			query := base.Query()
+			query.Set(apiKeyParam, key)
+			base.RawQuery = query.Encode()
+		}
+
+		allowlist := clientAllowlist(r.Context())
+		allowed := make(map[string]ff.Pipeline, len(pipelines))
+
+		for name, pipeline := range pipelines {
+			if allowlist.allows(name, pipeline.URL) == nil {
+				allowed[name] = pipeline
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+
+		if err := ff.WriteOPML(w, opmlTitle, base.String(), allowed); err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// servedURL returns baseURL, or the root of the host the request was sent to
+// when it is empty. The Host header is client supplied, so anything but a
+// plain host and port is refused.
+func servedURL(r *http.Request, baseURL string) (*url.URL, error) {
+	if baseURL != "" {
+		return parseBaseURL(baseURL)
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	u, err := url.Parse(scheme + "://" + r.Host + "/")
+	if err != nil || r.Host == "" || u.Host != r.Host || u.User != nil || u.Path != "/" || u.RawQuery != "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHost, r.Host)
+	}
+
+	return u, nil
+}
+
+// parseBaseURL parses the URL ff is served at, an absolute http or https URL.
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidBaseURL, baseURL)
+	}
+
+	return u, nil
+}
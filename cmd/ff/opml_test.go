@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestCLIOPMLImport(t *testing.T) {
+	t.Parallel()
+
+	opmlPath := filepath.Join(t.TempDir(), "subscriptions.opml")
+	assert.NilError(t, os.WriteFile(opmlPath, []byte(`<opml version="2.0"><body>
+<outline text="News"><outline text="Acme" xmlUrl="https://acme.example.com/rss"/></outline>
+<outline text="Existing" xmlUrl="https://example.com/feed"/>
+</body></opml>`), 0o600))
+
+	configPath := writeTestConfig(t, `{
+  "upstream": {"hosts": [{"host": "example.com", "bearerToken": "${TOKEN}"}]},
+  "pipelines": {"existing": {"url": "https://example.com/feed"}}
+}`)
+
+	out, err := runTestCLI(t, "", "opml", "import", "-config=")
+	assert.NilError(t, err, "without a config an empty one is extended")
+	assert.Assert(t, cmp.Contains(out, `"pipelines": {}`))
+
+	c, stdout := newTestCLI("")
+	err = c.run(context.Background(), []string{
+		"opml", "import", "-config", configPath, "-folder-query", "News=title.contains=go&rm.content", opmlPath,
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Contains(stdout.String(), "${TOKEN}"), "environment references are kept")
+
+	var config Config
+	assert.NilError(t, json.Unmarshal(stdout.Bytes(), &config))
+	assert.Equal(t, len(config.Pipelines), 2)
+	assert.DeepEqual(t, config.Pipelines["acme"], ff.Pipeline{
+		URL: "https://acme.example.com/rss", Title: "Acme", Folder: "News", Query: "title.contains=go&rm.content",
+	})
+}
+
+func TestCLIOPMLExport(t *testing.T) {
+	t.Parallel()
+
+	configPath := writeTestConfig(t, `{"pipelines": {"acme": {"url": "https://acme.example.com/rss", "folder": "News"}}}`)
+
+	c, stdout := newTestCLI("")
+
+	err := c.run(context.Background(), []string{"opml", "export", "-config", configPath, "-base-url", "https://ff.example.com/"})
+	assert.NilError(t, err)
+	assert.Assert(t, cmp.Contains(stdout.String(), `xmlUrl="https://ff.example.com/?pipeline=acme"`))
+
+	_, err = runTestCLI(t, "", "opml", "sync")
+	assert.Assert(t, err != nil)
+}
+
+func TestOPMLHandler(t *testing.T) {
+	t.Parallel()
+
+	handler := opmlHandler(map[string]ff.Pipeline{"acme": {URL: "https://acme.example.com/rss"}}, AuthConfig{}, "")
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "http://ff.internal:8080/opml", nil)
+	rec := httptest.NewRecorder()
+
+	handler(rec, req)
+
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, rec.Header().Get("Content-Type"), "text/x-opml; charset=utf-8")
+	assert.Assert(t, cmp.Contains(rec.Body.String(), `xmlUrl="http://ff.internal:8080/?pipeline=acme"`))
+}
+
+func TestOPMLHandlerRequiresCredentials(t *testing.T) {
+	t.Parallel()
+
+	config, err := loadConfig("")
+	assert.NilError(t, err)
+
+	config.Auth = AuthConfig{Keys: []APIKey{
+		{Name: "reader", Key: "reader-key"},
+		{Name: "acme", Key: "acme-key", Allowlist: Allowlist{Pipelines: []string{"acme"}}},
+	}}
+	config.Pipelines = map[string]ff.Pipeline{
+		"acme":  {URL: "https://acme.example.com/rss"},
+		"other": {URL: "https://other.example.com/rss"},
+	}
+
+	mux, _, err := newServeMux(config, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+	assert.NilError(t, err)
+
+	request := func(target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+		maps.Copy(req.Header, header)
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	assert.Equal(t, request("http://ff.internal/opml", nil).Code, http.StatusUnauthorized)
+	assert.Equal(t, request("http://ff.internal/metrics", nil).Code, http.StatusUnauthorized)
+	assert.Equal(t, request("http://ff.internal/metrics?key=reader-key", nil).Code, http.StatusOK)
+
+	rec := request("http://ff.internal/opml?key=reader-key", nil)
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Assert(t, cmp.Contains(rec.Body.String(), `xmlUrl="http://ff.internal/?key=reader-key&amp;pipeline=other"`))
+
+	rec = request("http://ff.internal/opml", http.Header{"X-Api-Key": {"acme-key"}})
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Assert(t, cmp.Contains(rec.Body.String(), `xmlUrl="http://ff.internal/?key=acme-key&amp;pipeline=acme"`))
+	assert.Assert(t, !strings.Contains(rec.Body.String(), "pipeline=other"), "only the allowed pipelines are listed")
+}
+
+func TestOPMLHandlerBaseURL(t *testing.T) {
+	t.Parallel()
+
+	pipelines := map[string]ff.Pipeline{"acme": {URL: "https://acme.example.com/rss"}}
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "http://evil.example.com/opml", nil)
+	rec := httptest.NewRecorder()
+	opmlHandler(pipelines, AuthConfig{}, "https://ff.example.com/feeds/")(rec, req)
+
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Assert(t, cmp.Contains(rec.Body.String(), `xmlUrl="https://ff.example.com/feeds/?pipeline=acme"`))
+
+	req = httptest.NewRequestWithContext(context.Background(), http.MethodGet, "http://ff.internal/opml", nil)
+	req.Host = "evil.example.com/phish?"
+	rec = httptest.NewRecorder()
+	opmlHandler(pipelines, AuthConfig{}, "")(rec, req)
+
+	assert.Equal(t, rec.Code, http.StatusBadRequest)
+}
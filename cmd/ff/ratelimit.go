@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nakatanakatana/ff"
+)
+
+// bucketSweepInterval is how often idle, full buckets are forgotten.
+const bucketSweepInterval = time.Minute
+
+var (
+	ErrInvalidRateLimit = errors.New("invalid rate limit configuration")
+	ErrRateLimited      = errors.New("rate limit exceeded")
+)
+
+// Limit is a token bucket: Rate requests per second on average, in bursts of
+// up to Burst requests. A zero Rate disables the limit.
+type Limit struct {
+	Rate float64 `json:"rate,omitempty"`
+	// Burst defaults to Rate rounded up, and at least 1.
+	Burst int `json:"burst,omitempty"`
+}
+
+func (l Limit) withDefaults() Limit {
+	if l.Burst == 0 {
+		l.Burst = max(1, int(math.Ceil(l.Rate)))
+	}
+
+	return l
+}
+
+// RateLimitConfig limits feed requests per client IP address and, for
+// authenticated requests, also per credential.
+type RateLimitConfig struct {
+	PerIP Limit `json:"perIp"`
+	// PerKey applies to every credential of the auth configuration, unless
+	// Clients has a limit for the credential's name.
+	PerKey  Limit            `json:"perKey"`
+	Clients map[string]Limit `json:"clients,omitempty"`
+	// TrustedProxies are the addresses or CIDRs whose X-Forwarded-For header
+	// is believed. Connections over unix sockets are always trusted.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+}
+
+func (c RateLimitConfig) check() error {
+	limits := []Limit{c.PerIP, c.PerKey}
+	for _, limit := range c.Clients {
+		limits = append(limits, limit)
+	}
+
+	for _, limit := range limits {
+		if limit.Rate < 0 || limit.Burst < 0 {
+			return fmt.Errorf("%w: rate and burst cannot be negative", ErrInvalidRateLimit)
+		}
+	}
+
+	_, err := parseProxies(c.TrustedProxies)
+
+	return err
+}
+
+func parseProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: trusted proxy %q", ErrInvalidRateLimit, proxy)
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter keeps a token bucket per key.
+type limiter struct {
+	limit     Limit
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newLimiter(limit Limit) *limiter {
+	return &limiter{limit: limit.withDefaults(), buckets: make(map[string]*bucket)}
+}
+
+// take spends a token of key's bucket, or reports how long until one is available.
+func (l *limiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := float64(l.limit.Burst)
+
+	if now.Sub(l.lastSweep) >= bucketSweepInterval {
+		l.lastSweep = now
+
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate >= burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+// rateLimiter applies RateLimitConfig to requests.
+type rateLimiter struct {
+	perIP   *limiter
+	perKey  *limiter
+	clients map[string]*limiter
+	proxies []netip.Prefix
+	now     func() time.Time
+}
+
+func newRateLimiter(config RateLimitConfig) (*rateLimiter, error) {
+	proxies, err := parseProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &rateLimiter{clients: make(map[string]*limiter), proxies: proxies, now: time.Now}
+
+	if config.PerIP.Rate > 0 {
+		r.perIP = newLimiter(config.PerIP)
+	}
+
+	if config.PerKey.Rate > 0 {
+		r.perKey = newLimiter(config.PerKey)
+	}
+
+	for name, limit := range config.Clients {
+		if limit.Rate > 0 {
+			r.clients[name] = newLimiter(limit)
+		}
+	}
+
+	return r, nil
+}
+
+// clientIP is the connection's address, or, when the connection comes from a
+// trusted proxy, the last address in X-Forwarded-For not added by a trusted proxy.
+func (r *rateLimiter) clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err == nil && !r.trusted(addr) {
+		return addr.Unmap().String()
+	}
+
+	// Walk the forwarded chain back from the proxy closest to us.
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for _, hop := range slices.Backward(forwarded) {
+		hopAddr, err := netip.ParseAddr(strings.TrimSpace(hop))
+		if err != nil {
+			break
+		}
+
+		if !r.trusted(hopAddr) {
+			return hopAddr.Unmap().String()
+		}
+
+		host = hopAddr.Unmap().String()
+	}
+
+	return host
+}
+
+func (r *rateLimiter) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	return slices.ContainsFunc(r.proxies, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+}
+
+// limitIP counts every request against its client IP's bucket. It runs
+// before requireAuth, so requests with bad credentials are limited too.
+func (r *rateLimiter) limitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.perIP != nil && !r.allow(w, req, r.perIP, r.clientIP(req)) {
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// limitKey counts authenticated requests against their credential's bucket,
+// the one in clients under its name or else perKey. It runs after
+// requireAuth, which names the client.
+func (r *rateLimiter) limitKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := clientName(req.Context())
+
+		l, ok := r.clients[name]
+		if !ok {
+			l = r.perKey
+		}
+
+		if name != "" && l != nil && !r.allow(w, req, l, name) {
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// allow takes a token for key, or answers with 429 and a Retry-After header.
+func (r *rateLimiter) allow(w http.ResponseWriter, req *http.Request, l *limiter, key string) bool {
+	ok, wait := l.take(key, r.now())
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(w, req, &ff.FeedError{
+			Kind: ff.KindRateLimited,
+			Err:  fmt.Errorf("%w for %s", ErrRateLimited, key),
+		})
+	}
+
+	return ok
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func TestLimiterTake(t *testing.T) {
+	t.Parallel()
+
+	l := newLimiter(Limit{Rate: 0.5, Burst: 2})
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for range 2 {
+		ok, _ := l.take("a", now)
+		assert.Assert(t, ok, "the burst is available at once")
+	}
+
+	ok, wait := l.take("a", now)
+	assert.Assert(t, !ok)
+	assert.Equal(t, wait, 2*time.Second)
+
+	ok, _ = l.take("b", now)
+	assert.Assert(t, ok, "every key has its own bucket")
+
+	ok, _ = l.take("a", now.Add(2*time.Second))
+	assert.Assert(t, ok, "a token is added every 2s")
+
+	ok, wait = l.take("a", now.Add(3*time.Second))
+	assert.Assert(t, !ok)
+	assert.Equal(t, wait, time.Second)
+
+	l.take("c", now.Add(time.Hour))
+	assert.Equal(t, len(l.buckets), 1, "idle full buckets are forgotten")
+}
+
+func TestRateLimiterClientIP(t *testing.T) {
+	t.Parallel()
+
+	r, err := newRateLimiter(RateLimitConfig{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}})
+	assert.NilError(t, err)
+
+	for _, tt := range []struct {
+		name      string
+		remote    string
+		forwarded []string
+		expect    string
+	}{
+		{"direct", "198.51.100.7:1234", nil, "198.51.100.7"},
+		{"untrusted proxy", "198.51.100.7:1234", []string{"203.0.113.9"}, "198.51.100.7"},
+		{"trusted proxy", "10.1.2.3:1234", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"proxy chain", "10.1.2.3:1234", []string{"203.0.113.9, 198.51.100.1, 192.0.2.1"}, "198.51.100.1"},
+		{"repeated headers", "192.0.2.1:1234", []string{"203.0.113.9", "10.0.0.1"}, "203.0.113.9"},
+		{"unix socket", "@", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"garbage", "10.1.2.3:1234", []string{"unknown"}, "10.1.2.3"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			req.Header["X-Forwarded-For"] = tt.forwarded
+
+			assert.Equal(t, r.clientIP(req), tt.expect)
+		})
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	t.Parallel()
+
+	r, err := newRateLimiter(RateLimitConfig{
+		PerIP:   Limit{Rate: 1},
+		PerKey:  Limit{Rate: 1, Burst: 2},
+		Clients: map[string]Limit{"vip": {Rate: 100}},
+	})
+	assert.NilError(t, err)
+
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	// A stand-in for requireAuth naming the client from a header.
+	named := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		if client := req.Header.Get("X-Client"); client != "" {
+			ctx = context.WithValue(ctx, clientKey{}, client)
+		}
+
+		limited := r.limitKey(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		limited.ServeHTTP(w, req.WithContext(ctx))
+	})
+	handler := r.limitIP(named)
+
+	request := func(remote, client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?url=https://example.com/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Client", client)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		return w
+	}
+
+	assert.Equal(t, request("198.51.100.7:1", "").Code, http.StatusOK)
+
+	w := request("198.51.100.7:2", "")
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+	assert.Equal(t, w.Header().Get("Retry-After"), "1")
+
+	assert.Equal(t, request("198.51.100.8:1", "").Code, http.StatusOK, "other addresses are not affected")
+	assert.Equal(t, request("198.51.100.7:3", "reader").Code, http.StatusTooManyRequests,
+		"credentials do not lift the IP limit")
+
+	for i := range 2 {
+		assert.Equal(t, request(fmt.Sprintf("203.0.113.%d:1", i), "reader").Code, http.StatusOK)
+	}
+
+	assert.Equal(t, request("198.51.100.9:1", "reader").Code, http.StatusTooManyRequests, "a key is limited anywhere")
+
+	for i := range 10 {
+		assert.Equal(t, request(fmt.Sprintf("192.0.2.%d:1", i), "vip").Code, http.StatusOK)
+	}
+
+	now = now.Add(time.Second)
+	assert.Equal(t, request("198.51.100.7:5", "").Code, http.StatusOK, "tokens are refilled")
+}
+
+func TestRateLimiterLimitsUnauthorized(t *testing.T) {
+	t.Parallel()
+
+	config, err := loadConfig("")
+	assert.NilError(t, err)
+
+	config.Auth = AuthConfig{Keys: []APIKey{{Name: "reader", Key: "reader-key"}}}
+	config.RateLimit = RateLimitConfig{PerIP: Limit{Rate: 1, Burst: 2}}
+
+	mux, _, err := newServeMux(config, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+	assert.NilError(t, err)
+
+	codes := make([]int, 0, 3)
+
+	for range 3 {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet,
+			"/?url=https://example.com/&key=wrong", nil)
+		req.RemoteAddr = "198.51.100.7:1234"
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+
+	assert.DeepEqual(t, codes, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests})
+}
+
+func TestRateLimitConfigCheck(t *testing.T) {
+	t.Parallel()
+
+	assert.NilError(t, RateLimitConfig{PerIP: Limit{Rate: 1}, TrustedProxies: []string{"::1", "fd00::/8"}}.check())
+
+	err := RateLimitConfig{PerKey: Limit{Rate: -1}}.check()
+	assert.Assert(t, errors.Is(err, ErrInvalidRateLimit))
+
+	err = RateLimitConfig{TrustedProxies: []string{"proxy.internal"}}.check()
+	assert.Assert(t, errors.Is(err, ErrInvalidRateLimit))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HTTPIdleTimeout        = 120 * time.Second
+	defaultShutdownTimeout = 30 * time.Second
+	// unixAddrPrefix marks a listen address as a unix socket path.
+	unixAddrPrefix = "unix:"
+)
+
+var ErrInvalidServerSetting = errors.New("invalid server setting")
+
+// serverConfig holds the listener and HTTP server settings of serve. Each is
+// set by a flag defaulting to an environment variable.
+type serverConfig struct {
+	// Addr is host:port, or unix:<path> for a unix socket.
+	Addr            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	MaxHeaderBytes  int
+	// TLSCert and TLSKey, when set, serve HTTPS (and HTTP/2) with the pair,
+	// reloaded when the files change.
+	TLSCert string
+	TLSKey  string
+	// H2C accepts HTTP/2 without TLS, for proxies speaking HTTP/2 cleartext.
+	H2C bool
+}
+
+// register adds the server flags to flags, their defaults read with getenv.
+func (s *serverConfig) register(flags *flag.FlagSet, getenv func(string) string) error {
+	addr := getenv("LISTEN_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+
+	flags.StringVar(&s.Addr, "addr", addr, "listen address, host:port or unix:<path> (LISTEN_ADDR)")
+
+	for _, d := range []struct {
+		target   *time.Duration
+		flag     string
+		env      string
+		fallback time.Duration
+		usage    string
+	}{
+		{&s.ReadTimeout, "read-timeout", "READ_TIMEOUT", HTTPReadTimeout, "time to read a request"},
+		{&s.WriteTimeout, "write-timeout", "WRITE_TIMEOUT", HTTPWriteTimeout, "time to write a response"},
+		{&s.IdleTimeout, "idle-timeout", "IDLE_TIMEOUT", HTTPIdleTimeout, "time a keep-alive connection may idle"},
+		{
+			&s.ShutdownTimeout, "shutdown-timeout", "SHUTDOWN_TIMEOUT", defaultShutdownTimeout,
+			"time given to in-flight requests and background refreshes on shutdown",
+		},
+	} {
+		value := d.fallback
+
+		if env := getenv(d.env); env != "" {
+			parsed, err := time.ParseDuration(env)
+			if err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrInvalidServerSetting, d.env, err)
+			}
+
+			value = parsed
+		}
+
+		flags.DurationVar(d.target, d.flag, value, d.usage+" ("+d.env+")")
+	}
+
+	maxHeaderBytes := http.DefaultMaxHeaderBytes
+
+	if env := getenv("MAX_HEADER_BYTES"); env != "" {
+		parsed, err := strconv.Atoi(env)
+		if err != nil {
+			return fmt.Errorf("%w: MAX_HEADER_BYTES: %w", ErrInvalidServerSetting, err)
+		}
+
+		maxHeaderBytes = parsed
+	}
+
+	flags.IntVar(&s.MaxHeaderBytes, "max-header-bytes", maxHeaderBytes, "largest request header size (MAX_HEADER_BYTES)")
+
+	flags.StringVar(&s.TLSCert, "tls-cert", getenv("TLS_CERT_FILE"), "TLS certificate file (TLS_CERT_FILE)")
+	flags.StringVar(&s.TLSKey, "tls-key", getenv("TLS_KEY_FILE"), "TLS key file (TLS_KEY_FILE)")
+
+	h2c := false
+
+	if env := getenv("H2C"); env != "" {
+		parsed, err := strconv.ParseBool(env)
+		if err != nil {
+			return fmt.Errorf("%w: H2C: %w", ErrInvalidServerSetting, err)
+		}
+
+		h2c = parsed
+	}
+
+	flags.BoolVar(&s.H2C, "h2c", h2c, "accept HTTP/2 without TLS (H2C)")
+
+	return nil
+}
+
+// listen opens the listener for Addr. A socket file left behind by a previous
+// process is replaced.
+func (s serverConfig) listen(ctx context.Context) (net.Listener, error) {
+	var lc net.ListenConfig
+
+	path, isUnix := strings.CutPrefix(s.Addr, unixAddrPrefix)
+	if !isUnix {
+		listener, err := lc.Listen(ctx, "tcp", s.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", s.Addr, err)
+		}
+
+		return listener, nil
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := lc.Listen(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", s.Addr, err)
+	}
+
+	return listener, nil
+}
+
+// httpServer creates the server for handler, loading the TLS certificate
+// when one is configured.
+func (s serverConfig) httpServer(handler http.Handler) (*http.Server, error) {
+	if (s.TLSCert == "") != (s.TLSKey == "") {
+		return nil, fmt.Errorf("%w: -tls-cert and -tls-key must be set together", ErrInvalidServerSetting)
+	}
+
+	server := &http.Server{
+		Handler:        withDeadline(handler, s.WriteTimeout),
+		ReadTimeout:    s.ReadTimeout,
+		WriteTimeout:   s.WriteTimeout,
+		IdleTimeout:    s.IdleTimeout,
+		MaxHeaderBytes: s.MaxHeaderBytes,
+		Protocols:      new(http.Protocols),
+	}
+
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetUnencryptedHTTP2(s.H2C)
+
+	if s.TLSCert != "" {
+		reloader, err := newCertReloader(s.TLSCert, s.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+
+		server.Protocols.SetHTTP2(true)
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+	}
+
+	return server, nil
+}
+
+// withDeadline gives every request a context deadline of timeout, so upstream
+// fetches and their retries give up before the response could no longer be written.
+func withDeadline(next http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// serve serves on listener, with TLS when the server has a TLS configuration.
+func serve(server *http.Server, listener net.Listener) error {
+	if server.TLSConfig != nil {
+		return server.ServeTLS(listener, "", "") //nolint:wrapcheck
+	}
+
+	return server.Serve(listener) //nolint:wrapcheck
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+func TestServerConfigRegister(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{"LISTEN_ADDR": "unix:/run/ff.sock", "IDLE_TIMEOUT": "5s"}
+
+	var settings serverConfig
+
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	assert.NilError(t, settings.register(flags, func(name string) string { return env[name] }))
+	assert.NilError(t, flags.Parse([]string{"-read-timeout=2s", "-max-header-bytes=4096"}))
+
+	assert.DeepEqual(t, settings, serverConfig{
+		Addr:            "unix:/run/ff.sock",
+		ReadTimeout:     2 * time.Second,
+		WriteTimeout:    HTTPWriteTimeout,
+		IdleTimeout:     5 * time.Second,
+		ShutdownTimeout: defaultShutdownTimeout,
+		MaxHeaderBytes:  4096,
+	})
+
+	for _, name := range []string{"WRITE_TIMEOUT", "MAX_HEADER_BYTES"} {
+		err := (&serverConfig{}).register(flag.NewFlagSet("serve", flag.ContinueOnError),
+			func(key string) string {
+				if key == name {
+					return "soon"
+				}
+
+				return ""
+			})
+		assert.Assert(t, errors.Is(err, ErrInvalidServerSetting), name)
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	t.Parallel()
+
+	requested := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(requested)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer upstream.Close()
+
+	socket := filepath.Join(t.TempDir(), "ff.sock")
+	configPath := writeTestConfig(t, `{"upstream": {"access": {"allowedNetworks": ["127.0.0.0/8", "::1/128"]}}}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, _ := newTestCLI("")
+	stopped := make(chan error, 1)
+
+	go func() {
+		stopped <- c.run(ctx, []string{"serve", "-config", configPath, "-addr", "unix:" + socket})
+	}()
+
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		if _, err := os.Stat(socket); err != nil {
+			return poll.Continue("waiting for %s", socket)
+		}
+
+		return poll.Success()
+	})
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+
+	responses := make(chan result, 1)
+
+	go func() {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://ff/?url="+upstream.URL, nil)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			responses <- result{err: err}
+
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{resp.StatusCode, string(body), err}
+	}()
+
+	<-requested
+	cancel()
+
+	response := <-responses
+	assert.NilError(t, response.err)
+	assert.Equal(t, response.status, http.StatusOK, "the in-flight request is drained")
+	assert.Assert(t, len(response.body) > 0)
+
+	assert.NilError(t, <-stopped)
+
+	_, err := os.Stat(socket)
+	assert.Assert(t, errors.Is(err, os.ErrNotExist), "the socket is removed")
+}
+
+func startTestServer(t *testing.T, settings serverConfig) string {
+	t.Helper()
+
+	settings.Addr = "127.0.0.1:0"
+
+	server, err := settings.httpServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}))
+	assert.NilError(t, err)
+
+	listener, err := settings.listen(context.Background())
+	assert.NilError(t, err)
+
+	go func() {
+		_ = serve(server, listener)
+	}()
+
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	return listener.Addr().String()
+}
+
+func getProto(t *testing.T, client *http.Client, target string) string {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+	assert.NilError(t, err)
+
+	resp, err := client.Do(req)
+	assert.NilError(t, err)
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NilError(t, err)
+
+	return string(body)
+}
+
+func TestServeTLS(t *testing.T) {
+	t.Parallel()
+
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "ff")
+	addr := startTestServer(t, serverConfig{TLSCert: certFile, TLSKey: keyFile})
+
+	pemBytes, err := os.ReadFile(certFile)
+	assert.NilError(t, err)
+
+	roots := x509.NewCertPool()
+	assert.Assert(t, roots.AppendCertsFromPEM(pemBytes))
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		ForceAttemptHTTP2: true,
+	}}
+
+	assert.Equal(t, getProto(t, client, "https://"+addr+"/"), "HTTP/2.0")
+
+	_, err = serverConfig{TLSCert: certFile}.httpServer(http.NotFoundHandler())
+	assert.Assert(t, errors.Is(err, ErrInvalidServerSetting), "a certificate needs its key")
+}
+
+func TestServeH2C(t *testing.T) {
+	t.Parallel()
+
+	addr := startTestServer(t, serverConfig{H2C: true})
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	assert.Equal(t, getProto(t, client, "http://"+addr+"/"), "HTTP/2.0")
+
+	assert.Equal(t, getProto(t, http.DefaultClient, "http://"+addr+"/"), "HTTP/1.1", "HTTP/1.1 is still served")
+}
+
+func TestWithDeadline(t *testing.T) {
+	t.Parallel()
+
+	var remaining time.Duration
+
+	handler := withDeadline(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		deadline, ok := r.Context().Deadline()
+		assert.Assert(t, ok, "requests get the write timeout as deadline")
+
+		remaining = time.Until(deadline)
+	}), 30*time.Second)
+
+	handler.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil))
+	assert.Assert(t, remaining > 29*time.Second && remaining <= 30*time.Second, "%v", remaining)
+}
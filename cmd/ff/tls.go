@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes.
+const certCheckInterval = time.Second
+
+// certReloader serves a certificate and key pair from disk and loads it again
+// when either file changes, so renewed certificates are picked up without a
+// restart. A pair that fails to load, e.g. while only one of the files has
+// been replaced, leaves the previous certificate in use.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime [2]time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: certCheckInterval}
+
+	modTime, err := r.modTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.checked) >= r.interval {
+		r.checked = now
+
+		if modTime, err := r.modTimes(); err == nil && modTime != r.modTime {
+			if err := r.load(modTime); err != nil {
+				slog.Warn("keeping the previous TLS certificate", "error", err)
+			} else {
+				slog.Info("reloaded the TLS certificate", "cert", r.certFile)
+			}
+		}
+	}
+
+	return r.cert, nil
+}
+
+func (r *certReloader) modTimes() ([2]time.Time, error) {
+	var modTime [2]time.Time
+
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTime, fmt.Errorf("failed to read TLS file: %w", err)
+		}
+
+		modTime[i] = info.ModTime()
+	}
+
+	return modTime, nil
+}
+
+func (r *certReloader) load(modTime [2]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 named
+// commonName to dir, returning the certificate and key paths.
+func writeTestCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NilError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	assert.NilError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NilError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile
+}
+
+func certCommonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NilError(t, err)
+
+	return parsed.Subject.CommonName
+}
+
+// touch moves the modification time of the files forward, as a replaced
+// file written within the same clock tick might not show a change.
+func touch(t *testing.T, offset time.Duration, paths ...string) {
+	t.Helper()
+
+	at := time.Now().Add(offset)
+	for _, path := range paths {
+		assert.NilError(t, os.Chtimes(path, at, at))
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "first")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	assert.NilError(t, err)
+
+	reloader.interval = 0
+
+	cert, err := reloader.GetCertificate(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, certCommonName(t, cert), "first")
+
+	writeTestCert(t, dir, "second")
+	touch(t, time.Minute, certFile, keyFile)
+
+	cert, err = reloader.GetCertificate(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, certCommonName(t, cert), "second", "changed files are loaded again")
+
+	assert.NilError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	touch(t, 2*time.Minute, keyFile)
+
+	cert, err = reloader.GetCertificate(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, certCommonName(t, cert), "second", "a broken pair keeps the previous certificate")
+
+	_, err = newCertReloader(certFile, filepath.Join(dir, "missing.pem"))
+	assert.ErrorContains(t, err, "failed to read TLS file")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const defaultServiceName = "ff"
+
+// TracingConfig enables OpenTelemetry tracing. Spans are exported over
+// OTLP/HTTP to Endpoint; tracing is off while it is empty.
+type TracingConfig struct {
+	// Endpoint is the collector URL, e.g. http://otel-collector:4318.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Headers are sent with every export, e.g. for authentication.
+	Headers map[string]string `json:"headers,omitempty"`
+	// SampleRatio is the fraction of new traces recorded; 0 records all of them.
+	SampleRatio float64 `json:"sampleRatio,omitempty"`
+	// ServiceName defaults to ff.
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+// newTracerProvider creates the provider exporting to the configured
+// endpoint, or returns nil when tracing is off.
+func newTracerProvider(ctx context.Context, config TracingConfig) (*sdktrace.TracerProvider, error) {
+	if config.Endpoint == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(config.Endpoint)}
+	if len(config.Headers) > 0 {
+		options = append(options, otlptracehttp.WithHeaders(config.Headers))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if config.SampleRatio > 0 {
+		sampler = sdktrace.TraceIDRatioBased(config.SampleRatio)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	), nil
+}
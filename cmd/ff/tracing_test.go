@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gotest.tools/v3/assert"
+)
+
+func TestNewTracerProvider(t *testing.T) {
+	t.Parallel()
+
+	provider, err := newTracerProvider(context.Background(), TracingConfig{})
+	assert.NilError(t, err)
+	assert.Assert(t, provider == nil, "tracing is off without an endpoint")
+
+	provider, err = newTracerProvider(context.Background(), TracingConfig{
+		Endpoint:    "http://127.0.0.1:4318",
+		SampleRatio: 0.5,
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, provider.Shutdown(context.Background()))
+}
+
+func TestHandlerTracesRender(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	handler := ff.TraceRequests(provider,
+		createHandler(newTestUpstream(t), nil, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap()))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?url="+server.URL, nil)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	var names []string
+	for _, span := range exporter.GetSpans() {
+		names = append(names, span.Name)
+	}
+
+	slices.Sort(names)
+	assert.DeepEqual(t, names, []string{"GET /", "filter", "parse", "render", "upstream.fetch"})
+}
@@ -1,6 +1,11 @@
 package ff
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
 	"github.com/gorilla/feeds"
 	"github.com/mmcdole/gofeed"
 )
@@ -73,3 +78,18 @@ func Convert(f *gofeed.Feed) *feeds.Feed {
 
 	return feed
 }
+
+// FeedETag returns a weak ETag for the rendered feed. Channel build dates are
+// left out, so upstream changes confined to filtered-out items keep the same ETag.
+func FeedETag(f *feeds.Feed) string {
+	stable := *f
+	stable.Updated = time.Time{}
+	stable.Created = time.Time{}
+
+	b, err := json.Marshal(stable)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf(`W/"%x"`, sha256.Sum256(b))
+}
@@ -2,7 +2,9 @@ package ff_test
 
 import (
 	"log"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mmcdole/gofeed"
 	"github.com/nakatanakatana/ff"
@@ -56,3 +58,22 @@ func TestConvertWithNil(t *testing.T) {
 		assert.Equal(t, 0, len(converted.Items))
 	})
 }
+
+func TestFeedETag(t *testing.T) {
+	t.Parallel()
+
+	updated := time.Date(2021, time.July, 11, 0, 0, 0, 0, time.UTC)
+	feed := &gofeed.Feed{
+		Title: "title",
+		Items: []*gofeed.Item{{Title: "kept"}},
+	}
+
+	etag := ff.FeedETag(ff.Convert(feed))
+	assert.Assert(t, strings.HasPrefix(etag, `W/"`))
+
+	feed.UpdatedParsed = &updated
+	assert.Equal(t, ff.FeedETag(ff.Convert(feed)), etag, "build dates should not change the ETag")
+
+	feed.Items = append(feed.Items, &gofeed.Item{Title: "added"})
+	assert.Assert(t, ff.FeedETag(ff.Convert(feed)) != etag, "items should change the ETag")
+}
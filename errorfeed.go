@@ -0,0 +1,36 @@
+package ff
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// ErrorItem describes a failure to fetch upstreamURL as a feed item, so feed
+// readers show the problem instead of silently dropping the feed. The GUID
+// only changes with the upstream, the error kind and the day, so a failure
+// lasting many polls shows up as one item a day.
+func ErrorItem(upstreamURL string, err error, now time.Time) *gofeed.Item {
+	feedErr := ClassifyError(err)
+
+	lines := []string{
+		"Error: " + feedErr.Error(),
+		"Kind: " + string(feedErr.Kind),
+	}
+	if feedErr.UpstreamStatus != 0 {
+		lines = append(lines, fmt.Sprintf("Upstream status: %d", feedErr.UpstreamStatus))
+	}
+
+	lines = append(lines, "Time: "+now.UTC().Format(time.RFC3339))
+
+	return &gofeed.Item{
+		Title:           fmt.Sprintf("ff could not fetch this feed (%s)", feedErr.Kind),
+		Description:     strings.Join(lines, "\n"),
+		Link:            upstreamURL,
+		GUID:            fmt.Sprintf("ff-error:%s:%s:%s", upstreamURL, feedErr.Kind, now.UTC().Format(time.DateOnly)),
+		PublishedParsed: &now,
+		UpdatedParsed:   &now,
+	}
+}
@@ -0,0 +1,34 @@
+package ff_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func TestErrorItem(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	err := ff.UpstreamStatusError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+
+	item := ff.ErrorItem("https://example.com/feed", err, now)
+
+	assert.Check(t, is.Contains(item.Title, string(ff.KindUpstreamStatus)))
+	assert.Check(t, is.Contains(item.Description, "404 Not Found"))
+	assert.Check(t, is.Contains(item.Description, "Upstream status: 404"))
+	assert.Check(t, is.Contains(item.Description, "Time: 2024-03-01T12:00:00Z"))
+	assert.Equal(t, item.Link, "https://example.com/feed")
+	assert.Equal(t, *item.PublishedParsed, now)
+	assert.Equal(t, item.GUID, "ff-error:https://example.com/feed:upstream_status:2024-03-01")
+
+	later := ff.ErrorItem("https://example.com/feed", err, now.Add(time.Hour))
+	assert.Equal(t, later.GUID, item.GUID, "repeated polls should not add items")
+
+	invalid := ff.ErrorItem("https://example.com/feed", ff.ErrInvalidFeed, now)
+	assert.Assert(t, invalid.GUID != item.GUID, "another kind of error is another item")
+}
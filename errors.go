@@ -0,0 +1,104 @@
+package ff
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+)
+
+var (
+	ErrInvalidURL  = errors.New("invalid upstream URL")
+	ErrInvalidFeed = errors.New("upstream did not serve a valid feed")
+)
+
+// ErrorKind tells client mistakes apart from the ways an upstream can fail.
+type ErrorKind string
+
+const (
+	KindInvalidRequest      ErrorKind = "invalid_request"
+	KindUnauthorized        ErrorKind = "unauthorized"
+	KindForbidden           ErrorKind = "forbidden"
+	KindRateLimited         ErrorKind = "rate_limited"
+	KindUpstreamStatus      ErrorKind = "upstream_status"
+	KindUpstreamTimeout     ErrorKind = "upstream_timeout"
+	KindUpstreamUnavailable ErrorKind = "upstream_unavailable"
+	KindInvalidFeed         ErrorKind = "invalid_feed"
+	KindLimitExceeded       ErrorKind = "limit_exceeded"
+	KindInternal            ErrorKind = "internal"
+)
+
+// FeedError is a classified failure to serve a feed.
+type FeedError struct {
+	Kind ErrorKind
+	// UpstreamStatus is the status code the upstream answered with, if any.
+	UpstreamStatus int
+	Err            error
+}
+
+func (e *FeedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FeedError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus maps the failure to the status ff answers with: 4xx for client
+// mistakes, 502 for upstream failures and 504 for upstream timeouts.
+func (e *FeedError) HTTPStatus() int {
+	switch e.Kind {
+	case KindInvalidRequest:
+		return http.StatusBadRequest
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindRateLimited:
+		return http.StatusTooManyRequests
+	case KindUpstreamTimeout:
+		return http.StatusGatewayTimeout
+	case KindUpstreamStatus, KindUpstreamUnavailable, KindInvalidFeed, KindLimitExceeded:
+		return http.StatusBadGateway
+	case KindInternal:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ClassifyError wraps err in a FeedError describing where the failure came from.
+func ClassifyError(err error) *FeedError {
+	if err == nil {
+		return nil
+	}
+
+	var feedErr *FeedError
+	if errors.As(err, &feedErr) {
+		return feedErr
+	}
+
+	var (
+		statusErr  UpstreamStatusError
+		networkErr net.Error
+	)
+
+	switch {
+	case errors.Is(err, ErrInvalidURL), errors.Is(err, ErrUnknownPipeline):
+		return &FeedError{Kind: KindInvalidRequest, Err: err}
+	case errors.Is(err, ErrForbiddenUpstream):
+		return &FeedError{Kind: KindForbidden, Err: err}
+	case errors.As(err, &statusErr):
+		return &FeedError{Kind: KindUpstreamStatus, UpstreamStatus: statusErr.StatusCode, Err: err}
+	case errors.Is(err, ErrLimitExceeded):
+		return &FeedError{Kind: KindLimitExceeded, Err: err}
+	case errors.Is(err, ErrInvalidFeed):
+		return &FeedError{Kind: KindInvalidFeed, Err: err}
+	case errors.As(err, &networkErr) && networkErr.Timeout():
+		return &FeedError{Kind: KindUpstreamTimeout, Err: err}
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, fs.ErrNotExist), errors.As(err, &networkErr):
+		return &FeedError{Kind: KindUpstreamUnavailable, Err: err}
+	default:
+		return &FeedError{Kind: KindInternal, Err: err}
+	}
+}
@@ -0,0 +1,66 @@
+package ff_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+
+	var _ net.Error = timeoutError{}
+
+	for _, tt := range []struct {
+		name           string
+		err            error
+		kind           ff.ErrorKind
+		status         int
+		upstreamStatus int
+	}{
+		{"invalid url", fmt.Errorf("%w: x", ff.ErrInvalidURL), ff.KindInvalidRequest, http.StatusBadRequest, 0},
+		{
+			"unauthorized", &ff.FeedError{Kind: ff.KindUnauthorized, Err: errors.New("missing API key")},
+			ff.KindUnauthorized, http.StatusUnauthorized, 0,
+		},
+		{
+			"rate limited", &ff.FeedError{Kind: ff.KindRateLimited, Err: errors.New("slow down")},
+			ff.KindRateLimited, http.StatusTooManyRequests, 0,
+		},
+		{"forbidden", ff.ErrForbiddenUpstream, ff.KindForbidden, http.StatusForbidden, 0},
+		{
+			"upstream status",
+			fmt.Errorf("fetch: %w", ff.UpstreamStatusError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}),
+			ff.KindUpstreamStatus, http.StatusBadGateway, http.StatusNotFound,
+		},
+		{"invalid feed", fmt.Errorf("%w: eof", ff.ErrInvalidFeed), ff.KindInvalidFeed, http.StatusBadGateway, 0},
+		{"limit", fmt.Errorf("%w: too big", ff.ErrLimitExceeded), ff.KindLimitExceeded, http.StatusBadGateway, 0},
+		{"timeout", fmt.Errorf("fetch: %w", timeoutError{}), ff.KindUpstreamTimeout, http.StatusGatewayTimeout, 0},
+		{"circuit open", ff.ErrCircuitOpen, ff.KindUpstreamUnavailable, http.StatusBadGateway, 0},
+		{"internal", errors.New("boom"), ff.KindInternal, http.StatusInternalServerError, 0},
+		{"canceled", context.Canceled, ff.KindInternal, http.StatusInternalServerError, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			feedErr := ff.ClassifyError(tt.err)
+			assert.Equal(t, feedErr.Kind, tt.kind)
+			assert.Equal(t, feedErr.HTTPStatus(), tt.status)
+			assert.Equal(t, feedErr.UpstreamStatus, tt.upstreamStatus)
+			assert.Assert(t, errors.Is(feedErr, tt.err))
+			assert.Equal(t, ff.ClassifyError(feedErr), feedErr, "classifying twice should be stable")
+		})
+	}
+}
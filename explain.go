@@ -0,0 +1,59 @@
+package ff
+
+import (
+	"context"
+	"maps"
+	"net/url"
+	"slices"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// FilterDecision is the verdict of one filter on one item.
+type FilterDecision struct {
+	Key   string
+	Value string
+	Kept  bool
+}
+
+// ItemDecision tells whether an item survives the filters of a query, and why.
+type ItemDecision struct {
+	Item      *gofeed.Item
+	Kept      bool
+	Decisions []FilterDecision
+}
+
+// Explain runs every filter of the query against every item without
+// short-circuiting, so each decision can be shown. Filters are reported in key order.
+func Explain(ctx context.Context, f *gofeed.Feed, queries url.Values, filtersMap FilterFuncMap) []ItemDecision {
+	type namedFilter struct {
+		key, value string
+		filter     FilterFunc
+	}
+
+	var filters []namedFilter
+
+	for _, key := range slices.Sorted(maps.Keys(queries)) {
+		for _, value := range queries[key] {
+			if filter := CreateFilter(key, value, filtersMap); filter != nil {
+				filters = append(filters, namedFilter{key, value, filter})
+			}
+		}
+	}
+
+	decisions := make([]ItemDecision, 0, len(f.Items))
+
+	for _, item := range f.Items {
+		decision := ItemDecision{Item: item, Kept: true}
+
+		for _, filter := range filters {
+			kept := filter.filter(ctx, item)
+			decision.Kept = decision.Kept && kept
+			decision.Decisions = append(decision.Decisions, FilterDecision{Key: filter.key, Value: filter.value, Kept: kept})
+		}
+
+		decisions = append(decisions, decision)
+	}
+
+	return decisions
+}
@@ -0,0 +1,42 @@
+package ff_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+
+	kept := createTestItem()
+	dropped := createTestItem()
+	dropped.Title = "other"
+
+	feed := &gofeed.Feed{Items: []*gofeed.Item{kept, dropped}}
+	queries := url.Values{
+		"title.equal":    {"title"},
+		"link.contains":  {"github.com"},
+		"rm.description": {""},
+	}
+
+	decisions := ff.Explain(context.Background(), feed, queries, ff.CreateFiltersMap(nil, nil))
+	assert.Equal(t, len(decisions), 2)
+
+	assert.Assert(t, decisions[0].Kept)
+	assert.DeepEqual(t, decisions[0].Decisions, []ff.FilterDecision{
+		{Key: "link.contains", Value: "github.com", Kept: true},
+		{Key: "title.equal", Value: "title", Kept: true},
+	})
+
+	assert.Assert(t, !decisions[1].Kept)
+	assert.DeepEqual(t, decisions[1].Decisions, []ff.FilterDecision{
+		{Key: "link.contains", Value: "github.com", Kept: true},
+		{Key: "title.equal", Value: "title", Kept: false},
+	})
+	assert.Equal(t, len(feed.Items), 2, "explain must not change the feed")
+}
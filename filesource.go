@@ -0,0 +1,77 @@
+package ff
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// FileConfig lists the directories file:// upstreams may be read from. No
+// file can be read while AllowedDirs is empty.
+type FileConfig struct {
+	AllowedDirs []string `json:"allowedDirs,omitempty"`
+}
+
+func isFileURL(upstreamURL string) bool {
+	u, err := url.Parse(upstreamURL)
+
+	return err == nil && u.Scheme == "file"
+}
+
+// readFile reads the file named by a file:// URL. It is opened through
+// os.Root, so neither ".." nor symlinks can leave the allowed directory.
+func (f FileConfig) readFile(upstreamURL string, limits LimitsConfig) ([]byte, error) {
+	u, err := url.Parse(upstreamURL)
+	if err != nil || (u.Host != "" && u.Host != "localhost") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidURL, upstreamURL)
+	}
+
+	path := filepath.Clean(filepath.FromSlash(u.Path))
+
+	for _, dir := range f.AllowedDirs {
+		rel, err := filepath.Rel(filepath.Clean(dir), path)
+		if err != nil || !filepath.IsLocal(rel) {
+			continue
+		}
+
+		file, err := os.OpenInRoot(dir, rel)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to open upstream file: %w", err)
+		}
+
+		if err != nil {
+			// Symlinks escaping the root end up here as well as permission errors.
+			return nil, fmt.Errorf("%w: %w", ErrForbiddenUpstream, err)
+		}
+		defer file.Close()
+
+		return limits.readBody(file, -1)
+	}
+
+	return nil, fmt.Errorf("%w: %s is outside the allowed directories", ErrForbiddenUpstream, path)
+}
+
+// fetchFile reads a file:// upstream. Files are read on every fetch, which is
+// cheap, and only stored again when their content changed.
+func (u *UpstreamCache) fetchFile(upstreamURL string) (*UpstreamDocument, error) {
+	body, err := u.Files.readFile(upstreamURL, u.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached := u.lookup(upstreamURL); cached != nil && cached.version == fmt.Sprintf("%x", sha256.Sum256(body)) {
+		return cached.document(upstreamURL, body), nil
+	}
+
+	entry, err := u.store(upstreamURL, http.Header{}, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.document(upstreamURL, body), nil
+}
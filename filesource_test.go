@@ -0,0 +1,72 @@
+package ff_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func fileURL(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func TestUpstreamCacheFileSource(t *testing.T) {
+	t.Parallel()
+
+	allowed := t.TempDir()
+	outside := t.TempDir()
+
+	feedPath := filepath.Join(allowed, "feed.xml")
+	assert.NilError(t, os.WriteFile(feedPath, []byte(testUpstreamBody), 0o600))
+	assert.NilError(t, os.WriteFile(filepath.Join(outside, "secret.xml"), []byte(testUpstreamBody), 0o600))
+	assert.NilError(t, os.Symlink(filepath.Join(outside, "secret.xml"), filepath.Join(allowed, "link.xml")))
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	upstream.Files = ff.FileConfig{AllowedDirs: []string{allowed}}
+
+	doc, err := upstream.Fetch(context.Background(), fileURL(feedPath))
+	assert.NilError(t, err)
+	assert.Equal(t, string(doc.Body), testUpstreamBody)
+	assert.Equal(t, upstream.Version(fileURL(feedPath)), doc.Version)
+
+	for _, tt := range []struct {
+		name string
+		path string
+		kind ff.ErrorKind
+	}{
+		{"outside the allowed directories", filepath.Join(outside, "secret.xml"), ff.KindForbidden},
+		{"parent traversal", filepath.Join(allowed, "..", filepath.Base(outside), "secret.xml"), ff.KindForbidden},
+		{"symlink leaving the directory", filepath.Join(allowed, "link.xml"), ff.KindForbidden},
+		{"missing file", filepath.Join(allowed, "missing.xml"), ff.KindUpstreamUnavailable},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := upstream.Fetch(context.Background(), fileURL(tt.path))
+			assert.Assert(t, err != nil)
+			assert.Equal(t, ff.ClassifyError(err).Kind, tt.kind, "got %v", err)
+		})
+	}
+}
+
+func TestUpstreamCacheFileSourceDisabled(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	feedPath := filepath.Join(dir, "feed.xml")
+	assert.NilError(t, os.WriteFile(feedPath, []byte(testUpstreamBody), 0o600))
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	_, err = upstream.Fetch(context.Background(), fileURL(feedPath))
+	assert.Assert(t, errors.Is(err, ff.ErrForbiddenUpstream))
+}
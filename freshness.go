@@ -0,0 +1,280 @@
+package ff
+
+import (
+	"bytes"
+	"encoding/xml"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syndicationNamespace = "http://purl.org/rss/1.0/modules/syndication/"
+	// maxSkippedHours bounds the skipHours/skipDays walk to one week.
+	maxSkippedHours = 7 * 24
+	hoursPerDay     = 24
+	daysPerWeek     = 7
+	daysPerMonth    = 30
+	daysPerYear     = 365
+)
+
+// TTL reports how long the upstream asks the document to be cached. HTTP
+// caching headers (Cache-Control s-maxage / max-age / no-cache, then Expires)
+// take precedence; without them the feed's own <ttl> and sy:updatePeriod are used.
+func (d *UpstreamDocument) TTL() time.Duration {
+	if lifetime, ok := headerLifetime(d.Header); ok {
+		return lifetime
+	}
+
+	hints := parseFeedHints(d.Body)
+
+	return max(hints.ttl, hints.updatePeriod)
+}
+
+// FreshUntil reports until when the document may be served without contacting
+// the upstream: TTL after it was fetched, pushed past any hours or days the
+// feed lists in <skipHours> / <skipDays>.
+func (d *UpstreamDocument) FreshUntil() time.Time {
+	freshUntil := d.FetchedAt.Add(d.TTL())
+	hints := parseFeedHints(d.Body)
+
+	for range maxSkippedHours {
+		utc := freshUntil.UTC()
+		if !slices.Contains(hints.skipHours, utc.Hour()) && !slices.Contains(hints.skipDays, utc.Weekday()) {
+			break
+		}
+
+		freshUntil = utc.Truncate(time.Hour).Add(time.Hour)
+	}
+
+	return freshUntil
+}
+
+func headerLifetime(header http.Header) (time.Duration, bool) {
+	cacheControl := header.Get("Cache-Control")
+	if hasDirective(cacheControl, "no-store") || hasDirective(cacheControl, "no-cache") {
+		return 0, true
+	}
+
+	maxAge, ok := parseDirectiveSeconds(cacheControl, "s-maxage")
+	if !ok {
+		maxAge, ok = parseDirectiveSeconds(cacheControl, "max-age")
+	}
+
+	if ok {
+		if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+			maxAge -= time.Duration(age) * time.Second
+		}
+
+		return max(maxAge, 0), true
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// An invalid Expires means "already expired".
+			return 0, true
+		}
+
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+
+		return max(expiresAt.Sub(date), 0), true
+	}
+
+	return 0, false
+}
+
+func hasDirective(cacheControl string, name string) bool {
+	for directive := range strings.SplitSeq(cacheControl, ",") {
+		directiveName, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(directiveName, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseDirectiveSeconds(cacheControl string, name string) (time.Duration, bool) {
+	for directive := range strings.SplitSeq(cacheControl, ",") {
+		directiveName, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(directiveName, name) {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || seconds < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	return 0, false
+}
+
+// setDirectiveSeconds replaces the value of the named directive with d,
+// rounded up to whole seconds, keeping the other directives.
+func setDirectiveSeconds(cacheControl string, name string, d time.Duration) string {
+	directives := strings.Split(cacheControl, ",")
+
+	for i, directive := range directives {
+		directives[i] = strings.TrimSpace(directive)
+
+		directiveName, _, _ := strings.Cut(directives[i], "=")
+		if strings.EqualFold(directiveName, name) {
+			directives[i] = directiveName + "=" + strconv.Itoa(int(math.Ceil(max(d, 0).Seconds())))
+		}
+	}
+
+	return strings.Join(directives, ", ")
+}
+
+// parseRetryAfter accepts both forms of Retry-After: delay seconds and an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return now.Add(time.Duration(max(seconds, 0)) * time.Second), true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return at, true
+	}
+
+	return time.Time{}, false
+}
+
+// feedHints are the channel level caching hints published inside a feed.
+type feedHints struct {
+	ttl          time.Duration
+	updatePeriod time.Duration
+	skipHours    []int
+	skipDays     []time.Weekday
+}
+
+// parseFeedHints scans the channel header of an RSS document, stopping at the
+// first item so large feeds are not decoded twice.
+func parseFeedHints(body []byte) feedHints {
+	var (
+		hints           feedHints
+		period          string
+		updateFrequency = 1
+	)
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			// io.EOF or a syntax error: either way there are no more hints
+			break
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if start.Name.Local == "item" || start.Name.Local == "entry" {
+			break
+		}
+
+		var text struct {
+			Value string   `xml:",chardata"`
+			Hours []string `xml:"hour"`
+			Days  []string `xml:"day"`
+		}
+		if !isHintElement(start.Name) || decoder.DecodeElement(&text, &start) != nil {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "ttl":
+			if m, err := strconv.Atoi(strings.TrimSpace(text.Value)); err == nil && m > 0 {
+				hints.ttl = time.Duration(m) * time.Minute
+			}
+		case "updatePeriod":
+			period = strings.TrimSpace(text.Value)
+		case "updateFrequency":
+			if f, err := strconv.Atoi(strings.TrimSpace(text.Value)); err == nil && f > 0 {
+				updateFrequency = f
+			}
+		case "skipHours":
+			hints.skipHours = parseSkipHours(text.Hours)
+		case "skipDays":
+			hints.skipDays = parseSkipDays(text.Days)
+		}
+	}
+
+	if p := updatePeriodDuration(period); p > 0 {
+		hints.updatePeriod = p / time.Duration(updateFrequency)
+	}
+
+	return hints
+}
+
+func isHintElement(name xml.Name) bool {
+	switch name.Local {
+	case "ttl", "skipHours", "skipDays":
+		return name.Space == ""
+	case "updatePeriod", "updateFrequency":
+		return name.Space == syndicationNamespace || name.Space == "sy"
+	default:
+		return false
+	}
+}
+
+func updatePeriodDuration(period string) time.Duration {
+	day := hoursPerDay * time.Hour
+
+	switch period {
+	case "hourly":
+		return time.Hour
+	case "daily":
+		return day
+	case "weekly":
+		return daysPerWeek * day
+	case "monthly":
+		return daysPerMonth * day
+	case "yearly":
+		return daysPerYear * day
+	default:
+		return 0
+	}
+}
+
+func parseSkipHours(values []string) []int {
+	hours := make([]int, 0, len(values))
+
+	for _, v := range values {
+		if h, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && h >= 0 && h < hoursPerDay {
+			hours = append(hours, h)
+		}
+	}
+
+	return hours
+}
+
+func parseSkipDays(values []string) []time.Weekday {
+	days := make([]time.Weekday, 0, len(values))
+
+	for _, v := range values {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(strings.TrimSpace(v), d.String()) {
+				days = append(days, d)
+			}
+		}
+	}
+
+	return days
+}
@@ -0,0 +1,91 @@
+package ff_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func TestUpstreamDocumentTTL(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name         string
+		cacheControl string
+		body         string
+		expect       time.Duration
+	}{
+		{"no hints", "", testUpstreamBody, 0},
+		{"max-age", "public, max-age=300", testUpstreamBody, 5 * time.Minute},
+		{
+			"rss ttl", "",
+			`<rss version="2.0"><channel><title>t</title><ttl>60</ttl><item><ttl>1</ttl></item></channel></rss>`,
+			time.Hour,
+		},
+		{
+			"max-age wins over ttl", "max-age=10",
+			`<rss version="2.0"><channel><ttl>60</ttl></channel></rss>`,
+			10 * time.Second,
+		},
+		{"invalid max-age", "max-age=soon", testUpstreamBody, 0},
+		{"s-maxage wins over max-age", "max-age=10, s-maxage=20", testUpstreamBody, 20 * time.Second},
+		{"no-cache overrides ttl", "no-cache", `<rss><channel><ttl>60</ttl></channel></rss>`, 0},
+		{
+			"sy updatePeriod", "",
+			`<rss xmlns:sy="http://purl.org/rss/1.0/modules/syndication/"><channel>
+<sy:updatePeriod>daily</sy:updatePeriod><sy:updateFrequency>4</sy:updateFrequency></channel></rss>`,
+			6 * time.Hour,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			doc := &ff.UpstreamDocument{
+				Body:   []byte(tt.body),
+				Header: http.Header{},
+			}
+			doc.Header.Set("Cache-Control", tt.cacheControl)
+
+			assert.Equal(t, doc.TTL(), tt.expect)
+		})
+	}
+}
+
+func TestUpstreamDocumentTTLFromExpires(t *testing.T) {
+	t.Parallel()
+
+	date := time.Date(2021, time.July, 11, 0, 0, 0, 0, time.UTC)
+	doc := &ff.UpstreamDocument{Header: http.Header{}}
+	doc.Header.Set("Date", date.Format(http.TimeFormat))
+	doc.Header.Set("Expires", date.Add(time.Hour).Format(http.TimeFormat))
+
+	assert.Equal(t, doc.TTL(), time.Hour)
+
+	doc.Header.Set("Cache-Control", "max-age=120")
+	doc.Header.Set("Age", "20")
+	assert.Equal(t, doc.TTL(), 100*time.Second, "max-age minus Age should win over Expires")
+}
+
+func TestUpstreamDocumentFreshUntilSkipHours(t *testing.T) {
+	t.Parallel()
+
+	// 2021-07-11 is a Sunday
+	fetchedAt := time.Date(2021, time.July, 11, 1, 30, 0, 0, time.UTC)
+	doc := &ff.UpstreamDocument{
+		Header:    http.Header{},
+		FetchedAt: fetchedAt,
+		Body: []byte(`<rss><channel><ttl>60</ttl>
+<skipHours><hour>2</hour><hour>3</hour></skipHours>
+<skipDays><day>Monday</day></skipDays>
+</channel></rss>`),
+	}
+
+	assert.Equal(t, doc.FreshUntil(), time.Date(2021, time.July, 11, 4, 0, 0, 0, time.UTC))
+
+	doc.FetchedAt = time.Date(2021, time.July, 11, 23, 30, 0, 0, time.UTC)
+	assert.Equal(t, doc.FreshUntil(), time.Date(2021, time.July, 13, 0, 0, 0, 0, time.UTC),
+		"skipped days should push freshness to the next allowed day")
+}
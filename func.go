@@ -2,7 +2,9 @@ package ff
 
 import (
 	"context"
+	"maps"
 	"net/url"
+	"slices"
 
 	"github.com/mmcdole/gofeed"
 )
@@ -12,21 +14,24 @@ func ParseQueries(queries url.Values,
 	modifiersMap ModifierFuncMap) ([]FilterFunc,
 	[]ModifierFunc,
 ) {
+	// Keys are sorted so the filter credited with dropping an item is stable.
+	keys := slices.Sorted(maps.Keys(queries))
+
 	var filters []FilterFunc
 
-	for key, values := range queries {
-		for _, v := range values {
+	for _, key := range keys {
+		for _, v := range queries[key] {
 			f := CreateFilter(key, v, filtersMap)
 			if f != nil {
-				filters = append(filters, f)
+				filters = append(filters, countDrops(key, f))
 			}
 		}
 	}
 
 	var modifiers []ModifierFunc
 
-	for key, values := range queries {
-		for _, v := range values {
+	for _, key := range keys {
+		for _, v := range queries[key] {
 			m := CreateModifier(key, v, modifiersMap)
 			if m != nil {
 				modifiers = append(modifiers, m)
@@ -37,7 +42,25 @@ func ParseQueries(queries url.Values,
 	return filters, modifiers
 }
 
+// countDrops wraps a filter so the items it rejects are counted under key in
+// the Metrics carried by the context. Apply stops at the first rejecting
+// filter, so each dropped item is counted once.
+func countDrops(key string, f FilterFunc) FilterFunc {
+	return func(ctx context.Context, i *gofeed.Item) bool {
+		if f(ctx, i) {
+			return true
+		}
+
+		metricsFrom(ctx).itemDropped(key)
+
+		return false
+	}
+}
+
 func Apply(ctx context.Context, f *gofeed.Feed, ff []FilterFunc, mf []ModifierFunc) (*gofeed.Feed, error) {
+	ctx, span := StartSpan(ctx, "filter", AttrItemsBefore.Int(len(f.Items)))
+	defer span.End()
+
 	items := make([]*gofeed.Item, len(f.Items))
 	count := 0
 
@@ -49,7 +72,11 @@ func Apply(ctx context.Context, f *gofeed.Feed, ff []FilterFunc, mf []ModifierFu
 		}
 	}
 
+	Logger(ctx).DebugContext(ctx, "apply", "items", len(f.Items), "kept", count)
+
 	f.Items = items[:count]
 
+	span.SetAttributes(AttrItemsAfter.Int(count))
+
 	return f, nil
 }
@@ -0,0 +1,169 @@
+package ff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/mmcdole/gofeed"
+)
+
+var ErrInvalidSelector = errors.New("invalid CSS selector")
+
+// dateLayouts are tried in turn when a source has no DateFormat.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	"January 2, 2006",
+	"2 January 2006",
+	"Jan 2, 2006",
+}
+
+// HTMLSource scrapes a page without a feed. Item selects the element of each
+// entry; the other selectors are evaluated inside it.
+type HTMLSource struct {
+	Item string `json:"item"`
+	// Title defaults to the text of the link.
+	Title string `json:"title,omitempty"`
+	// Link selects the element whose href is used, defaulting to the first
+	// link in the item. Relative links are resolved against the page URL.
+	Link string `json:"link,omitempty"`
+	// Date selects the element holding the date, read from its datetime
+	// attribute if it has one and from its text otherwise.
+	Date string `json:"date,omitempty"`
+	// DateFormat is a Go time layout; common formats are tried when empty.
+	DateFormat  string `json:"dateFormat,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Check reports selectors that do not compile.
+func (s HTMLSource) Check() error {
+	if s.Item == "" {
+		return fmt.Errorf("%w: item selector is required", ErrInvalidSelector)
+	}
+
+	for _, selector := range []string{s.Item, s.Title, s.Link, s.Date, s.Description} {
+		if selector == "" {
+			continue
+		}
+
+		if _, err := cascadia.Compile(selector); err != nil {
+			return fmt.Errorf("%w: %q: %w", ErrInvalidSelector, selector, err)
+		}
+	}
+
+	return nil
+}
+
+// Parse builds a feed from the page at pageURL. A page where the item
+// selector matches nothing is reported as ErrInvalidFeed, as the page layout
+// has most likely changed.
+func (s HTMLSource) Parse(body []byte, pageURL string) (*gofeed.Feed, error) {
+	if err := s.Check(); err != nil {
+		return nil, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFeed, err)
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidURL, pageURL)
+	}
+
+	description, _ := doc.Find(`meta[name="description"]`).Attr("content")
+	feed := &gofeed.Feed{
+		Title:       strings.TrimSpace(doc.Find("title").First().Text()),
+		Link:        pageURL,
+		Description: description,
+	}
+
+	doc.FindMatcher(cascadia.MustCompile(s.Item)).Each(func(_ int, item *goquery.Selection) {
+		feed.Items = append(feed.Items, s.item(item, base))
+	})
+
+	if len(feed.Items) == 0 {
+		return nil, fmt.Errorf("%w: item selector %q matched nothing", ErrInvalidFeed, s.Item)
+	}
+
+	return feed, nil
+}
+
+func (s HTMLSource) item(selection *goquery.Selection, base *url.URL) *gofeed.Item {
+	item := &gofeed.Item{}
+
+	linkElement := find(selection, s.Link)
+	if s.Link == "" {
+		linkElement = selection.Filter("a[href]").AddSelection(selection.Find("a[href]")).First()
+	}
+
+	if href, ok := linkElement.Attr("href"); ok {
+		if resolved, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			item.Link = resolved.String()
+			item.GUID = item.Link
+		}
+	}
+
+	item.Title = strings.TrimSpace(linkElement.Text())
+	if s.Title != "" {
+		item.Title = strings.TrimSpace(find(selection, s.Title).Text())
+	}
+
+	if s.Description != "" {
+		if description, err := find(selection, s.Description).Html(); err == nil {
+			item.Description = strings.TrimSpace(description)
+		}
+	}
+
+	if s.Date != "" {
+		dateElement := find(selection, s.Date)
+
+		value, ok := dateElement.Attr("datetime")
+		if !ok {
+			value = dateElement.Text()
+		}
+
+		if date, ok := parseDate(strings.TrimSpace(value), s.DateFormat); ok {
+			item.Published = strings.TrimSpace(value)
+			item.PublishedParsed = &date
+		}
+	}
+
+	return item
+}
+
+// find returns the first element matching selector inside selection.
+func find(selection *goquery.Selection, selector string) *goquery.Selection {
+	if selector == "" {
+		return selection.Slice(0, 0)
+	}
+
+	return selection.FindMatcher(cascadia.MustCompile(selector)).First()
+}
+
+// parseDate parses value with layout, or with the common layouts when layout is empty.
+func parseDate(value string, layout string) (time.Time, bool) {
+	layouts := dateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+
+	for _, layout := range layouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,104 @@
+package ff_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+const testHTMLPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Acme News</title>
+  <meta name="description" content="News from Acme">
+</head>
+<body>
+  <article class="post">
+    <h2><a href="/posts/1">First post</a></h2>
+    <time datetime="2024-03-01T10:00:00Z">March 1</time>
+    <div class="summary"><p>First <b>summary</b></p></div>
+  </article>
+  <article class="post">
+    <h2><a href="https://other.example.com/2">Second post</a></h2>
+    <span class="date">February 2, 2024</span>
+    <div class="summary">Second summary</div>
+  </article>
+</body>
+</html>`
+
+func newHTMLServer(t *testing.T, page string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(page))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestHTMLSource(t *testing.T) {
+	t.Parallel()
+
+	server := newHTMLServer(t, testHTMLPage)
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	doc, err := upstream.Fetch(context.Background(), server.URL+"/news")
+	assert.NilError(t, err)
+
+	pipeline := ff.Pipeline{
+		URL:    server.URL + "/news",
+		Source: ff.SourceHTML,
+		HTML: &ff.HTMLSource{
+			Item:        "article.post",
+			Title:       "h2",
+			Date:        "time, .date",
+			Description: ".summary",
+		},
+	}
+	assert.NilError(t, pipeline.Check())
+
+	feed, err := pipeline.Parse(context.Background(), upstream, doc)
+	assert.NilError(t, err)
+
+	assert.Equal(t, feed.Title, "Acme News")
+	assert.Equal(t, feed.Description, "News from Acme")
+	assert.Assert(t, is.Len(feed.Items, 2))
+
+	first := feed.Items[0]
+	assert.Equal(t, first.Title, "First post")
+	assert.Equal(t, first.Link, server.URL+"/posts/1", "relative links are resolved against the page")
+	assert.Equal(t, first.GUID, first.Link)
+	assert.Equal(t, first.Description, "<p>First <b>summary</b></p>")
+	assert.Equal(t, *first.PublishedParsed, time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC))
+
+	second := feed.Items[1]
+	assert.Equal(t, second.Link, "https://other.example.com/2")
+	assert.Equal(t, *second.PublishedParsed, time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC))
+}
+
+func TestHTMLSourceErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := ff.HTMLSource{Item: "article.missing"}.Parse([]byte(testHTMLPage), "https://example.com/")
+	assert.Assert(t, errors.Is(err, ff.ErrInvalidFeed))
+
+	assert.Assert(t, errors.Is(ff.HTMLSource{Item: "article["}.Check(), ff.ErrInvalidSelector))
+	assert.Assert(t, errors.Is(ff.HTMLSource{}.Check(), ff.ErrInvalidSelector))
+
+	source := ff.HTMLSource{Item: "article", Date: ".date", DateFormat: "2006/01/02"}
+	feed, err := source.Parse([]byte(testHTMLPage), "https://example.com/")
+	assert.NilError(t, err)
+	assert.Assert(t, feed.Items[1].PublishedParsed == nil, "dates not matching DateFormat are left empty")
+	assert.Equal(t, feed.Items[0].Title, "First post", "the title defaults to the link text")
+}
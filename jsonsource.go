@@ -0,0 +1,179 @@
+package ff
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmespath/go-jmespath"
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	// DateFormatUnix reads dates as seconds since the epoch.
+	DateFormatUnix = "unix"
+	// DateFormatUnixMilli reads dates as milliseconds since the epoch.
+	DateFormatUnixMilli = "unixms"
+)
+
+var ErrInvalidExpression = errors.New("invalid JMESPath expression")
+
+// JSONSource reads items from a JSON API. Items is a JMESPath expression
+// selecting the array of items; the field mappings are JMESPath expressions
+// evaluated against each item.
+type JSONSource struct {
+	Items       string `json:"items"`
+	Title       string `json:"title,omitempty"`
+	Link        string `json:"link,omitempty"`
+	GUID        string `json:"guid,omitempty"`
+	Description string `json:"description,omitempty"`
+	Content     string `json:"content,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Date        string `json:"date,omitempty"`
+	// DateFormat is a Go time layout, DateFormatUnix or DateFormatUnixMilli;
+	// common formats are tried when empty.
+	DateFormat string `json:"dateFormat,omitempty"`
+}
+
+// Check reports expressions that do not compile.
+func (s JSONSource) Check() error {
+	if s.Items == "" {
+		return fmt.Errorf("%w: items expression is required", ErrInvalidExpression)
+	}
+
+	for _, expression := range s.expressions() {
+		if expression == "" {
+			continue
+		}
+
+		if _, err := jmespath.Compile(expression); err != nil {
+			return fmt.Errorf("%w: %q: %w", ErrInvalidExpression, expression, err)
+		}
+	}
+
+	return nil
+}
+
+func (s JSONSource) expressions() []string {
+	return []string{s.Items, s.Title, s.Link, s.GUID, s.Description, s.Content, s.Author, s.Date}
+}
+
+// Parse builds a feed from the JSON document fetched from apiURL. Documents
+// where Items does not select an array are reported as ErrInvalidFeed.
+func (s JSONSource) Parse(body []byte, apiURL string) (*gofeed.Feed, error) {
+	if err := s.Check(); err != nil {
+		return nil, err
+	}
+
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFeed, err)
+	}
+
+	base, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidURL, apiURL)
+	}
+
+	selected, err := jmespath.Search(s.Items, data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFeed, err)
+	}
+
+	entries, ok := selected.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: items expression %q did not select an array", ErrInvalidFeed, s.Items)
+	}
+
+	feed := &gofeed.Feed{Title: apiURL, Link: apiURL, Items: make([]*gofeed.Item, 0, len(entries))}
+
+	for _, entry := range entries {
+		feed.Items = append(feed.Items, s.item(entry, base))
+	}
+
+	return feed, nil
+}
+
+func (s JSONSource) item(entry any, base *url.URL) *gofeed.Item {
+	item := &gofeed.Item{
+		Title:       field(entry, s.Title),
+		GUID:        field(entry, s.GUID),
+		Description: field(entry, s.Description),
+		Content:     field(entry, s.Content),
+	}
+
+	if link := field(entry, s.Link); link != "" {
+		if resolved, err := base.Parse(link); err == nil {
+			item.Link = resolved.String()
+		}
+	}
+
+	if item.GUID == "" {
+		item.GUID = item.Link
+	}
+
+	if author := field(entry, s.Author); author != "" {
+		item.Author = &gofeed.Person{Name: author}
+		item.Authors = []*gofeed.Person{item.Author}
+	}
+
+	if value := field(entry, s.Date); value != "" {
+		if date, ok := parseJSONDate(value, s.DateFormat); ok {
+			item.Published = value
+			item.PublishedParsed = &date
+		}
+	}
+
+	return item
+}
+
+// field evaluates expression against entry and returns the result as text.
+// Missing fields and errors yield "".
+func field(entry any, expression string) string {
+	if expression == "" {
+		return ""
+	}
+
+	value, err := jmespath.Search(expression, entry)
+	if err != nil {
+		return ""
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+
+		return string(b)
+	}
+}
+
+func parseJSONDate(value string, layout string) (time.Time, bool) {
+	switch layout {
+	case DateFormatUnix, DateFormatUnixMilli:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		if layout == DateFormatUnix {
+			return time.UnixMilli(int64(number * 1000)).UTC(), true //nolint:mnd
+		}
+
+		return time.UnixMilli(int64(number)).UTC(), true
+	default:
+		return parseDate(value, layout)
+	}
+}
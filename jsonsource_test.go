@@ -0,0 +1,90 @@
+package ff_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+const testJSONBody = `{
+  "data": {
+    "posts": [
+      {"id": 1, "attributes": {"name": "Go 1.24 released", "path": "/posts/1", "created": "2024-02-11", "by": "gopher"}},
+      {"id": 2, "attributes": {"name": "Rust news", "path": "https://rust.example.com/2", "created": "not a date"}}
+    ],
+    "meta": {"total": 2}
+  }
+}`
+
+func TestJSONSource(t *testing.T) {
+	t.Parallel()
+
+	source := ff.JSONSource{
+		Items:      "data.posts",
+		Title:      "attributes.name",
+		Link:       "attributes.path",
+		GUID:       "id",
+		Author:     "attributes.by",
+		Date:       "attributes.created",
+		DateFormat: "2006-01-02",
+	}
+	assert.NilError(t, source.Check())
+
+	feed, err := source.Parse([]byte(testJSONBody), "https://api.example.com/v1/posts")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(feed.Items, 2))
+
+	first := feed.Items[0]
+	assert.Equal(t, first.Title, "Go 1.24 released")
+	assert.Equal(t, first.Link, "https://api.example.com/posts/1")
+	assert.Equal(t, first.GUID, "1")
+	assert.Equal(t, first.Author.Name, "gopher")
+	assert.Equal(t, *first.PublishedParsed, time.Date(2024, time.February, 11, 0, 0, 0, 0, time.UTC))
+
+	second := feed.Items[1]
+	assert.Equal(t, second.Link, "https://rust.example.com/2")
+	assert.Assert(t, second.Author == nil)
+	assert.Assert(t, second.PublishedParsed == nil)
+
+	// Every filter applies to the mapped items.
+	queries, err := url.ParseQuery("title.contains=Go&author.equal=gopher")
+	assert.NilError(t, err)
+
+	filters, modifiers := ff.ParseQueries(queries, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+	filtered, err := ff.Apply(context.Background(), feed, filters, modifiers)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(filtered.Items, 1))
+}
+
+func TestJSONSourceUnixDates(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`[{"t": 1700000000}, {"t": 1700000000123}]`)
+
+	feed, err := ff.JSONSource{Items: "[0:1]", Date: "t", DateFormat: ff.DateFormatUnix}.Parse(body, "https://example.com/")
+	assert.NilError(t, err)
+	assert.Equal(t, *feed.Items[0].PublishedParsed, time.Unix(1700000000, 0).UTC())
+
+	feed, err = ff.JSONSource{Items: "[1:2]", Date: "t", DateFormat: ff.DateFormatUnixMilli}.Parse(body, "https://example.com/")
+	assert.NilError(t, err)
+	assert.Equal(t, *feed.Items[0].PublishedParsed, time.UnixMilli(1700000000123).UTC())
+}
+
+func TestJSONSourceErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := ff.JSONSource{Items: "data.meta"}.Parse([]byte(testJSONBody), "https://example.com/")
+	assert.Assert(t, errors.Is(err, ff.ErrInvalidFeed), "a non-array selection is an invalid feed")
+
+	_, err = ff.JSONSource{Items: "data"}.Parse([]byte("<rss/>"), "https://example.com/")
+	assert.Assert(t, errors.Is(err, ff.ErrInvalidFeed))
+
+	assert.Assert(t, errors.Is(ff.JSONSource{Items: "data.[", Title: "x"}.Check(), ff.ErrInvalidExpression))
+	assert.Assert(t, errors.Is(ff.JSONSource{}.Check(), ff.ErrInvalidExpression))
+}
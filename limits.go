@@ -0,0 +1,150 @@
+package ff
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	defaultMaxBodySize  = 10 << 20
+	defaultMaxItems     = 10000
+	defaultParseTimeout = 10 * time.Second
+	// defaultMaxCachedDocuments bounds the upstream disk cache, which sitemap
+	// pages fill with one document per page.
+	defaultMaxCachedDocuments = 1000
+)
+
+var ErrLimitExceeded = errors.New("upstream limit exceeded")
+
+// LimitsConfig bounds what an upstream may make ff read and parse. Zero values
+// select the defaults and -1 disables a limit.
+type LimitsConfig struct {
+	// MaxBodySize is the largest upstream body accepted, in bytes.
+	MaxBodySize int64 `json:"maxBodySize,omitempty"`
+	// MaxItems is the largest number of items a parsed feed may hold.
+	MaxItems int `json:"maxItems,omitempty"`
+	// ParseTimeout bounds the time spent parsing one upstream document.
+	ParseTimeout Duration `json:"parseTimeout,omitempty"`
+	// MaxCachedDocuments is the largest number of upstream documents kept on
+	// disk; the least recently fetched are evicted beyond it.
+	MaxCachedDocuments int `json:"maxCachedDocuments,omitempty"`
+}
+
+func (l LimitsConfig) withDefaults() LimitsConfig {
+	if l.MaxBodySize == 0 {
+		l.MaxBodySize = defaultMaxBodySize
+	}
+
+	if l.MaxItems == 0 {
+		l.MaxItems = defaultMaxItems
+	}
+
+	if l.ParseTimeout == 0 {
+		l.ParseTimeout = Duration(defaultParseTimeout)
+	}
+
+	if l.MaxCachedDocuments == 0 {
+		l.MaxCachedDocuments = defaultMaxCachedDocuments
+	}
+
+	return l
+}
+
+// readBody reads at most MaxBodySize bytes, failing instead of truncating.
+func (l LimitsConfig) readBody(resp io.Reader, contentLength int64) ([]byte, error) {
+	limit := l.withDefaults().MaxBodySize
+	if limit < 0 {
+		body, err := io.ReadAll(resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream body: %w", err)
+		}
+
+		return body, nil
+	}
+
+	if contentLength > limit {
+		return nil, fmt.Errorf("%w: body of %d bytes is larger than %d bytes", ErrLimitExceeded, contentLength, limit)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upstream body: %w", err)
+	}
+
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("%w: body is larger than %d bytes", ErrLimitExceeded, limit)
+	}
+
+	return body, nil
+}
+
+type parseResult struct {
+	feed *gofeed.Feed
+	err  error
+}
+
+// ParseFeed parses an upstream document within ParseTimeout and rejects feeds
+// holding more than MaxItems items. Documents that are not feeds are reported
+// as ErrInvalidFeed.
+func (l LimitsConfig) ParseFeed(ctx context.Context, body []byte) (*gofeed.Feed, error) {
+	return l.parse(ctx, func(context.Context) (*gofeed.Feed, error) {
+		feed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFeed, err)
+		}
+
+		return feed, nil
+	})
+}
+
+// parse runs a source's parser under ParseTimeout and MaxItems. The parser's
+// context ends with the deadline, so fetches it makes are bounded as well.
+func (l LimitsConfig) parse(
+	ctx context.Context, parse func(context.Context) (*gofeed.Feed, error),
+) (*gofeed.Feed, error) {
+	limits := l.withDefaults()
+
+	if limits.ParseTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(limits.ParseTimeout))
+		defer cancel()
+	}
+
+	// Parsers cannot be interrupted, so a parse running past the deadline is
+	// left to finish in the background; MaxBodySize keeps that bounded.
+	done := make(chan parseResult, 1)
+
+	go func() {
+		feed, err := parse(ctx)
+		done <- parseResult{feed, err}
+	}()
+
+	var result parseResult
+
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: parsing took longer than %s", ErrLimitExceeded, time.Duration(limits.ParseTimeout))
+		}
+
+		return nil, fmt.Errorf("parse canceled: %w", ctx.Err())
+	}
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	if limits.MaxItems >= 0 && len(result.feed.Items) > limits.MaxItems {
+		return nil, fmt.Errorf("%w: feed has %d items, more than %d", ErrLimitExceeded, len(result.feed.Items), limits.MaxItems)
+	}
+
+	return result.feed, nil
+}
@@ -0,0 +1,74 @@
+package ff_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func feedWithItems(n int) string {
+	return `<?xml version="1.0" encoding="UTF-8" ?><rss version="2.0"><channel><title>RSS Title</title>` +
+		strings.Repeat("<item><title>item</title><link>https://example.com/</link></item>", n) +
+		`</channel></rss>`
+}
+
+func TestUpstreamCacheMaxBodySize(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(testUpstreamBody))
+	}))
+	defer server.Close()
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	upstream.Limits = ff.LimitsConfig{MaxBodySize: 16}
+
+	_, err = upstream.Fetch(context.Background(), server.URL)
+	assert.Assert(t, errors.Is(err, ff.ErrLimitExceeded))
+	assert.Equal(t, upstream.Version(server.URL), "", "oversized bodies should not be cached")
+
+	upstream.Limits = ff.LimitsConfig{MaxBodySize: -1}
+
+	doc, err := upstream.Fetch(context.Background(), server.URL)
+	assert.NilError(t, err)
+	assert.Equal(t, string(doc.Body), testUpstreamBody)
+}
+
+func TestParseFeedLimits(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name   string
+		limits ff.LimitsConfig
+		body   string
+		err    error
+	}{
+		{"defaults", ff.LimitsConfig{}, feedWithItems(3), nil},
+		{"too many items", ff.LimitsConfig{MaxItems: 2}, feedWithItems(3), ff.ErrLimitExceeded},
+		{"item limit disabled", ff.LimitsConfig{MaxItems: -1}, feedWithItems(3), nil},
+		{"parse deadline", ff.LimitsConfig{ParseTimeout: 1}, feedWithItems(5000), ff.ErrLimitExceeded},
+		{"not a feed", ff.LimitsConfig{}, "<html></html>", ff.ErrInvalidFeed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			feed, err := tt.limits.ParseFeed(context.Background(), []byte(tt.body))
+			if tt.err != nil {
+				assert.Assert(t, errors.Is(err, tt.err), "got %v", err)
+
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.Equal(t, feed.Title, "RSS Title")
+		})
+	}
+}
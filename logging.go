@@ -0,0 +1,162 @@
+package ff
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RequestIDHeader carries the request ID to and from clients.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+type (
+	requestIDKey struct{}
+	loggerKey    struct{}
+)
+
+// ContextWithRequestID returns a context carrying the request ID id.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// ContextWithLogger returns a context carrying logger, which Logger returns.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger returns the logger carried by ctx, or slog.Default. Loggers set up by
+// LogRequests already hold the request ID.
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+// LogRequests gives every request an ID, taken from the X-Request-ID header
+// when the client sent a usable one, returns it in the response header and
+// logs one access line per request. The ID and a logger holding it are put in
+// the request context for Apply, upstream fetches and the cache to log with.
+// A nil logger logs to slog.Default. The values of the query parameters named
+// in redact, such as secrets, are left out of the log.
+func LogRequests(logger *slog.Logger, next http.Handler, redact ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+
+		base := logger
+		if base == nil {
+			base = slog.Default()
+		}
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+
+		requestLogger := base.With("request_id", id)
+		ctx := ContextWithLogger(ContextWithRequestID(r.Context(), id), requestLogger)
+
+		w.Header().Set(RequestIDHeader, id)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		requestLogger.LogAttrs(ctx, slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("query", redactQuery(r.URL.RawQuery, redact)),
+			slog.Int("status", sw.status),
+			slog.Int64("bytes", sw.bytes),
+			slog.Duration("duration", time.Since(started)),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+func redactQuery(rawQuery string, redact []string) string {
+	if len(redact) == 0 {
+		return rawQuery
+	}
+
+	queries, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+
+	changed := false
+
+	for _, name := range redact {
+		if values, ok := queries[name]; ok {
+			for i := range values {
+				values[i] = "REDACTED"
+			}
+
+			changed = true
+		}
+	}
+
+	if !changed {
+		return rawQuery
+	}
+
+	return queries.Encode()
+}
+
+// validRequestID accepts short IDs of printable ASCII, so client supplied IDs
+// cannot forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range []byte(id) {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// statusWriter remembers the status and size of a response for the access log.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.status = statusCode
+		w.wroteHeader = true
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += int64(n)
+
+	return n, err //nolint:wrapcheck
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
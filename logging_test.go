@@ -0,0 +1,123 @@
+package ff_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+
+	for line := range strings.SplitSeq(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		assert.NilError(t, json.Unmarshal([]byte(line), &entry))
+
+		lines = append(lines, entry)
+	}
+
+	return lines
+}
+
+func TestLogRequests(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	handler := ff.LogRequests(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := ff.Apply(r.Context(), &gofeed.Feed{Items: []*gofeed.Item{{Title: "a"}}}, nil, nil)
+		assert.NilError(t, err)
+
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte(ff.RequestID(r.Context())))
+	}))
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?url=https://example.com/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	id := w.Header().Get(ff.RequestIDHeader)
+	assert.Assert(t, id != "")
+	assert.Equal(t, w.Body.String(), id, "the handler sees the request ID in its context")
+
+	lines := decodeLogLines(t, &buf)
+	assert.Equal(t, len(lines), 2)
+
+	assert.Equal(t, lines[0]["msg"], "apply")
+	assert.Equal(t, lines[0]["request_id"], id)
+
+	access := lines[1]
+	assert.Equal(t, access["msg"], "request")
+	assert.Equal(t, access["request_id"], id)
+	assert.Equal(t, access["status"], float64(http.StatusTeapot))
+	assert.Equal(t, access["bytes"], float64(len(id)))
+	assert.Equal(t, access["query"], "url=https://example.com/")
+}
+
+func TestLogRequestsRedact(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	handler := ff.LogRequests(slog.New(slog.NewJSONHandler(&buf, nil)), http.NotFoundHandler(), "key")
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?key=secret&pipeline=news", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := decodeLogLines(t, &buf)
+	assert.Equal(t, lines[0]["query"], "key=REDACTED&pipeline=news")
+}
+
+func TestLogRequestsClientID(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.DiscardHandler)
+	handler := ff.LogRequests(logger, http.NotFoundHandler())
+
+	for _, tt := range []struct {
+		name   string
+		header string
+		kept   bool
+	}{
+		{"valid", "abc-123", true},
+		{"control characters", "abc\n123", false},
+		{"too long", strings.Repeat("a", 129), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			req.Header.Set(ff.RequestIDHeader, tt.header)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			id := w.Header().Get(ff.RequestIDHeader)
+			assert.Assert(t, id != "")
+			assert.Equal(t, id == tt.header, tt.kept)
+		})
+	}
+}
+
+func TestLoggerDefault(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, ff.Logger(context.Background()), slog.Default())
+	assert.Equal(t, ff.RequestID(context.Background()), "")
+
+	ctx := ff.ContextWithRequestID(context.Background(), "id-1")
+	assert.Equal(t, ff.RequestID(ctx), "id-1")
+}
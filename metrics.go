@@ -0,0 +1,145 @@
+package ff
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// OtherHost labels upstream fetches from hosts not listed in Metrics.Hosts.
+const OtherHost = "other"
+
+// Cache outcomes counted by Metrics.
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheStale = "stale"
+	CacheEvict = "evict"
+)
+
+// Metrics holds ff's Prometheus collectors. Methods on a nil *Metrics do
+// nothing, so instrumented types work without metrics.
+type Metrics struct {
+	Requests         *prometheus.CounterVec
+	Cache            *prometheus.CounterVec
+	UpstreamDuration *prometheus.HistogramVec
+	ParseFailures    *prometheus.CounterVec
+	ItemsDropped     *prometheus.CounterVec
+	// Hosts are the upstream hosts with their own host label, as names or
+	// "*.example.com" wildcards labelled as written. Requests choose their
+	// upstream, so every other host is labelled OtherHost to bound the series.
+	Hosts []string
+}
+
+type metricsKey struct{}
+
+// NewMetrics creates the collectors and registers them with registerer.
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ff_requests_total",
+			Help: "Feed requests by response status code.",
+		}, []string{"code"}),
+		Cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ff_cache_total",
+			Help: "Rendered cache lookups by outcome (hit, miss, stale) and removed entries (evict).",
+		}, []string{"outcome"}),
+		UpstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ff_upstream_fetch_duration_seconds",
+			Help:    "Upstream fetch attempts by configured host (or other) and status code (error when none was received).",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"host", "code"}),
+		ParseFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ff_parse_failures_total",
+			Help: "Upstream documents that could not be turned into a feed, by source type.",
+		}, []string{"source"}),
+		ItemsDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ff_items_dropped_total",
+			Help: "Items dropped by Apply, by the key of the first filter rejecting them.",
+		}, []string{"filter"}),
+	}
+
+	registerer.MustRegister(m.Requests, m.Cache, m.UpstreamDuration, m.ParseFailures, m.ItemsDropped)
+
+	return m
+}
+
+// Middleware counts the responses of next by status code and makes the
+// metrics available to Apply through the request context.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+
+	return promhttp.InstrumentHandlerCounter(m.Requests, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(ContextWithMetrics(r.Context(), m)))
+	}))
+}
+
+// ContextWithMetrics returns a context carrying m, for Apply to count dropped items.
+func ContextWithMetrics(ctx context.Context, m *Metrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+func metricsFrom(ctx context.Context) *Metrics {
+	m, _ := ctx.Value(metricsKey{}).(*Metrics)
+
+	return m
+}
+
+func (m *Metrics) cacheOutcome(outcome string) {
+	if m == nil {
+		return
+	}
+
+	m.Cache.WithLabelValues(outcome).Inc()
+}
+
+func (m *Metrics) upstreamFetch(host string, resp *http.Response, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+
+	m.UpstreamDuration.WithLabelValues(m.hostLabel(host), code).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) hostLabel(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+
+	for _, pattern := range m.Hosts {
+		if matchHost(pattern, host) {
+			return strings.ToLower(pattern)
+		}
+	}
+
+	return OtherHost
+}
+
+func (m *Metrics) parseFailure(source SourceType) {
+	if m == nil {
+		return
+	}
+
+	m.ParseFailures.WithLabelValues(string(source)).Inc()
+}
+
+func (m *Metrics) itemDropped(filter string) {
+	if m == nil {
+		return
+	}
+
+	m.ItemsDropped.WithLabelValues(filter).Inc()
+}
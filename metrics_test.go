@@ -0,0 +1,227 @@
+package ff_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/nakatanakatana/ff"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"gotest.tools/v3/assert"
+)
+
+func TestMetricsItemsDropped(t *testing.T) {
+	t.Parallel()
+
+	metrics := ff.NewMetrics(prometheus.NewRegistry())
+
+	queries := url.Values{}
+	queries.Set("title.contains", "go")
+	queries.Set("link.contains", "example.com")
+
+	filters, modifiers := ff.ParseQueries(queries, ff.CreateFiltersMap(nil, nil), ff.CreateModifierMap())
+
+	feed := &gofeed.Feed{Items: []*gofeed.Item{
+		{Title: "go news", Link: "https://example.com/1"},
+		{Title: "rust news", Link: "https://example.com/2"},
+		{Title: "go elsewhere", Link: "https://example.org/3"},
+		{Title: "other", Link: "https://example.org/4"},
+	}}
+
+	ctx := ff.ContextWithMetrics(context.Background(), metrics)
+	result, err := ff.Apply(ctx, feed, filters, modifiers)
+	assert.NilError(t, err)
+	assert.Equal(t, len(result.Items), 1)
+
+	// Filters run in key order, so the last item is credited to link.contains.
+	assert.Equal(t, testutil.ToFloat64(metrics.ItemsDropped.WithLabelValues("link.contains")), 2.0)
+	assert.Equal(t, testutil.ToFloat64(metrics.ItemsDropped.WithLabelValues("title.contains")), 1.0)
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	t.Parallel()
+
+	metrics := ff.NewMetrics(prometheus.NewRegistry())
+
+	handler := metrics.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("url") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	for _, target := range []string{"/?url=https://example.com/", "/", "/"} {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, testutil.ToFloat64(metrics.Requests.WithLabelValues("200")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(metrics.Requests.WithLabelValues("400")), 2.0)
+}
+
+func TestMetricsCacheAndUpstream(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("upstream body"))
+	}))
+	defer server.Close()
+
+	metrics := ff.NewMetrics(prometheus.NewRegistry())
+	metrics.Hosts = []string{"127.0.0.1"}
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	upstream.Metrics = metrics
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := upstream.Fetch(r.Context(), r.URL.Query().Get("url"))
+		assert.NilError(t, err)
+
+		_, _ = w.Write(doc.Body)
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(handler, t.TempDir())
+	assert.NilError(t, err)
+
+	middleware.Upstream = upstream
+	middleware.Metrics = metrics
+
+	params := url.Values{}
+	params.Set("url", server.URL)
+
+	for range 2 {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil)
+		middleware.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	cacheKey := middleware.GetCacheKey(params)
+	middleware.Purge(cacheKey)
+	middleware.Purge(cacheKey)
+
+	assert.Equal(t, testutil.ToFloat64(metrics.Cache.WithLabelValues(ff.CacheMiss)), 1.0)
+	assert.Equal(t, testutil.ToFloat64(metrics.Cache.WithLabelValues(ff.CacheHit)), 1.0)
+	assert.Equal(t, testutil.ToFloat64(metrics.Cache.WithLabelValues(ff.CacheEvict)), 1.0,
+		"only removed entries are evictions")
+
+	// The second request was served from the rendered cache without a fetch.
+	observer, err := metrics.UpstreamDuration.GetMetricWithLabelValues("127.0.0.1", "200")
+	assert.NilError(t, err)
+
+	var sample dto.Metric
+	assert.NilError(t, observer.(prometheus.Histogram).Write(&sample)) //nolint:forcetypeassert
+	assert.Equal(t, sample.GetHistogram().GetSampleCount(), uint64(1))
+	assert.Equal(t, testutil.CollectAndCount(metrics.UpstreamDuration), 1)
+}
+
+func TestMetricsStaleIsNotEviction(t *testing.T) {
+	t.Parallel()
+
+	var version atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "version %d", version.Add(1))
+	}))
+	defer server.Close()
+
+	metrics := ff.NewMetrics(prometheus.NewRegistry())
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := upstream.Fetch(r.Context(), r.URL.Query().Get("url"))
+		assert.NilError(t, err)
+
+		_, _ = w.Write(doc.Body)
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(handler, t.TempDir())
+	assert.NilError(t, err)
+
+	middleware.Upstream = upstream
+	middleware.Metrics = metrics
+
+	params := url.Values{}
+	params.Set("url", server.URL)
+
+	for range 2 {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?"+params.Encode(), nil)
+		middleware.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, testutil.ToFloat64(metrics.Cache.WithLabelValues(ff.CacheStale)), 1.0)
+	assert.Equal(t, testutil.ToFloat64(metrics.Cache.WithLabelValues(ff.CacheEvict)), 0.0,
+		"regenerating a stale entry is not an eviction")
+}
+
+func TestMetricsUpstreamHostLabel(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("upstream body"))
+	}))
+	defer server.Close()
+
+	metrics := ff.NewMetrics(prometheus.NewRegistry())
+	metrics.Hosts = []string{"*.example.com"}
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	upstream.Metrics = metrics
+
+	for _, path := range []string{"/a", "/b"} {
+		_, err := upstream.Fetch(context.Background(), server.URL+path)
+		assert.NilError(t, err)
+	}
+
+	assert.Equal(t, testutil.CollectAndCount(metrics.UpstreamDuration), 1)
+
+	observer, err := metrics.UpstreamDuration.GetMetricWithLabelValues(ff.OtherHost, "200")
+	assert.NilError(t, err)
+
+	var sample dto.Metric
+	assert.NilError(t, observer.(prometheus.Histogram).Write(&sample)) //nolint:forcetypeassert
+	assert.Equal(t, sample.GetHistogram().GetSampleCount(), uint64(2), "unlisted hosts share one label")
+}
+
+func TestMetricsParseFailures(t *testing.T) {
+	t.Parallel()
+
+	metrics := ff.NewMetrics(prometheus.NewRegistry())
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	upstream.Metrics = metrics
+
+	_, err = ff.Pipeline{}.Parse(context.Background(), upstream, &ff.UpstreamDocument{Body: []byte("not a feed")})
+	assert.ErrorIs(t, err, ff.ErrInvalidFeed)
+
+	assert.Equal(t, testutil.ToFloat64(metrics.ParseFailures.WithLabelValues("feed")), 1.0)
+}
+
+func TestMetricsNil(t *testing.T) {
+	t.Parallel()
+
+	var metrics *ff.Metrics
+
+	handler := metrics.Middleware(http.NotFoundHandler())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil))
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
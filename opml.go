@@ -0,0 +1,183 @@
+package ff
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// OPMLFeed is a feed subscription read from an OPML document.
+type OPMLFeed struct {
+	Title string
+	// Folder is the path of the outlines enclosing the feed, joined with "/".
+	Folder  string
+	URL     string
+	HTMLURL string
+}
+
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Body    []opmlOutline `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string        `xml:"htmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// ParseOPML reads the feeds of an OPML subscription list.
+func ParseOPML(r io.Reader) ([]OPMLFeed, error) {
+	var document opmlDocument
+	if err := xml.NewDecoder(r).Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to parse OPML: %w", err)
+	}
+
+	var feeds []OPMLFeed
+
+	var walk func(outlines []opmlOutline, folder string)
+	walk = func(outlines []opmlOutline, folder string) {
+		for _, outline := range outlines {
+			title := strings.TrimSpace(outline.Title)
+			if title == "" {
+				title = strings.TrimSpace(outline.Text)
+			}
+
+			if outline.XMLURL != "" {
+				feeds = append(feeds, OPMLFeed{Title: title, Folder: folder, URL: outline.XMLURL, HTMLURL: outline.HTMLURL})
+
+				continue
+			}
+
+			walk(outline.Outlines, strings.TrimPrefix(folder+"/"+title, "/"))
+		}
+	}
+	walk(document.Body, "")
+
+	return feeds, nil
+}
+
+// ImportOPML adds a pipeline for every feed whose URL no pipeline serves yet,
+// with the query of its folder from folderQueries. Pipelines are named after
+// the feed title. It returns the number of pipelines added.
+func ImportOPML(pipelines map[string]Pipeline, feeds []OPMLFeed, folderQueries map[string]string) int {
+	served := make(map[string]bool, len(pipelines))
+	for _, pipeline := range pipelines {
+		served[NormalizeURL(pipeline.URL)] = true
+	}
+
+	added := 0
+
+	for _, feed := range feeds {
+		if served[NormalizeURL(feed.URL)] {
+			continue
+		}
+
+		pipelines[uniqueName(pipelines, slug(feed))] = Pipeline{
+			URL:    feed.URL,
+			Title:  feed.Title,
+			Folder: feed.Folder,
+			Query:  folderQueries[feed.Folder],
+		}
+		served[NormalizeURL(feed.URL)] = true
+		added++
+	}
+
+	return added
+}
+
+func slug(feed OPMLFeed) string {
+	name := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(feed.Title), "-"), "-")
+	if name != "" {
+		return name
+	}
+
+	if u, err := url.Parse(feed.URL); err == nil && u.Hostname() != "" {
+		return strings.Trim(nonSlug.ReplaceAllString(u.Hostname(), "-"), "-")
+	}
+
+	return "feed"
+}
+
+func uniqueName(pipelines map[string]Pipeline, name string) string {
+	unique := name
+
+	for i := 2; ; i++ {
+		if _, taken := pipelines[unique]; !taken {
+			return unique
+		}
+
+		unique = name + "-" + strconv.Itoa(i)
+	}
+}
+
+// WriteOPML writes an OPML subscription list of the pipelines as served from
+// baseURL, grouped by folder, for loading into feed readers. Query parameters
+// of baseURL, such as key=, are kept on every feed URL.
+func WriteOPML(w io.Writer, title string, baseURL string, pipelines map[string]Pipeline) error {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidURL, baseURL)
+	}
+
+	document := opmlDocument{Version: "2.0", Title: title}
+	folders := map[string]int{}
+
+	for _, name := range slices.Sorted(maps.Keys(pipelines)) {
+		pipeline := pipelines[name]
+
+		query := base.Query()
+		query.Set("pipeline", name)
+
+		served := *base
+		served.RawQuery = query.Encode()
+
+		text := pipeline.Title
+		if text == "" {
+			text = name
+		}
+
+		outline := opmlOutline{Text: text, Type: "rss", XMLURL: served.String()}
+
+		if pipeline.Folder == "" {
+			document.Body = append(document.Body, outline)
+
+			continue
+		}
+
+		index, ok := folders[pipeline.Folder]
+		if !ok {
+			index = len(document.Body)
+			folders[pipeline.Folder] = index
+			document.Body = append(document.Body, opmlOutline{Text: pipeline.Folder})
+		}
+
+		document.Body[index].Outlines = append(document.Body[index].Outlines, outline)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write OPML: %w", err)
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	if err := encoder.Encode(document); err != nil {
+		return fmt.Errorf("failed to write OPML: %w", err)
+	}
+
+	return nil
+}
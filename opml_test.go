@@ -0,0 +1,87 @@
+package ff_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+const testOPML = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="Go Blog" type="rss" xmlUrl="https://go.dev/blog/feed.atom" htmlUrl="https://go.dev/blog"/>
+    <outline text="News">
+      <outline text="Tech" title="Tech News" type="rss" xmlUrl="https://tech.example.com/rss"/>
+      <outline text="Local">
+        <outline text="Tech" type="rss" xmlUrl="https://local.example.com/tech.xml"/>
+      </outline>
+    </outline>
+  </body>
+</opml>`
+
+func TestParseOPML(t *testing.T) {
+	t.Parallel()
+
+	feeds, err := ff.ParseOPML(strings.NewReader(testOPML))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, feeds, []ff.OPMLFeed{
+		{Title: "Go Blog", URL: "https://go.dev/blog/feed.atom", HTMLURL: "https://go.dev/blog"},
+		{Title: "Tech News", Folder: "News", URL: "https://tech.example.com/rss"},
+		{Title: "Tech", Folder: "News/Local", URL: "https://local.example.com/tech.xml"},
+	})
+
+	_, err = ff.ParseOPML(strings.NewReader("<opml"))
+	assert.ErrorContains(t, err, "failed to parse OPML")
+}
+
+func TestImportOPML(t *testing.T) {
+	t.Parallel()
+
+	feeds, err := ff.ParseOPML(strings.NewReader(testOPML))
+	assert.NilError(t, err)
+
+	pipelines := map[string]ff.Pipeline{
+		"tech":   {URL: "https://elsewhere.example.com/"},
+		"golang": {URL: "https://GO.dev:443/blog/feed.atom"},
+	}
+
+	added := ff.ImportOPML(pipelines, feeds, map[string]string{"News": "rm.content"})
+	assert.Equal(t, added, 2, "feeds already served are skipped")
+
+	assert.DeepEqual(t, pipelines["tech-news"], ff.Pipeline{
+		URL: "https://tech.example.com/rss", Title: "Tech News", Folder: "News", Query: "rm.content",
+	})
+	assert.DeepEqual(t, pipelines["tech-2"], ff.Pipeline{
+		URL: "https://local.example.com/tech.xml", Title: "Tech", Folder: "News/Local",
+	})
+}
+
+func TestWriteOPML(t *testing.T) {
+	t.Parallel()
+
+	pipelines := map[string]ff.Pipeline{
+		"go":   {URL: "https://go.dev/blog/feed.atom", Title: "Go Blog"},
+		"tech": {URL: "https://tech.example.com/rss", Folder: "News"},
+	}
+
+	var buf bytes.Buffer
+	assert.NilError(t, ff.WriteOPML(&buf, "ff", "https://ff.example.com/", pipelines))
+
+	feeds, err := ff.ParseOPML(&buf)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(feeds, 2))
+	assert.DeepEqual(t, feeds[0], ff.OPMLFeed{Title: "Go Blog", URL: "https://ff.example.com/?pipeline=go"})
+	assert.DeepEqual(t, feeds[1], ff.OPMLFeed{Title: "tech", Folder: "News", URL: "https://ff.example.com/?pipeline=tech"})
+
+	buf.Reset()
+	assert.NilError(t, ff.WriteOPML(&buf, "ff", "https://ff.example.com/?key=secret", pipelines))
+
+	feeds, err = ff.ParseOPML(&buf)
+	assert.NilError(t, err)
+	assert.Equal(t, feeds[0].URL, "https://ff.example.com/?key=secret&pipeline=go")
+}
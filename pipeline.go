@@ -0,0 +1,142 @@
+package ff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// SourceType selects how an upstream document is turned into a feed.
+type SourceType string
+
+const (
+	SourceFeed    SourceType = "feed"
+	SourceHTML    SourceType = "html"
+	SourceJSON    SourceType = "json"
+	SourceSitemap SourceType = "sitemap"
+)
+
+var (
+	ErrInvalidPipeline = errors.New("invalid pipeline")
+	ErrUnknownPipeline = errors.New("unknown pipeline")
+)
+
+// Pipeline is a named upstream together with the way to read it and the query
+// applied to it. Requests select it with pipeline=<name>.
+type Pipeline struct {
+	URL string `json:"url"`
+	// Source defaults to SourceFeed, an RSS, Atom or JSON feed.
+	Source SourceType  `json:"source,omitempty"`
+	HTML   *HTMLSource `json:"html,omitempty"`
+	JSON   *JSONSource `json:"json,omitempty"`
+	// Sitemap is optional for SourceSitemap.
+	Sitemap *SitemapSource `json:"sitemap,omitempty"`
+	// Query holds the filters and modifiers, written as in a request URL.
+	Query string `json:"query,omitempty"`
+	// Title and Folder describe the pipeline in OPML exports.
+	Title  string `json:"title,omitempty"`
+	Folder string `json:"folder,omitempty"`
+}
+
+// Check reports pipelines that could never produce a feed.
+func (p Pipeline) Check() error {
+	if u, err := url.Parse(p.URL); err != nil || !u.IsAbs() {
+		return fmt.Errorf("%w: url %q is not absolute", ErrInvalidPipeline, p.URL)
+	}
+
+	if _, err := url.ParseQuery(p.Query); err != nil {
+		return fmt.Errorf("%w: query: %w", ErrInvalidPipeline, err)
+	}
+
+	switch p.Source {
+	case "", SourceFeed:
+		return nil
+	case SourceHTML:
+		if p.HTML == nil {
+			return fmt.Errorf("%w: html source needs selectors", ErrInvalidPipeline)
+		}
+
+		return p.HTML.Check()
+	case SourceJSON:
+		if p.JSON == nil {
+			return fmt.Errorf("%w: json source needs field mappings", ErrInvalidPipeline)
+		}
+
+		return p.JSON.Check()
+	case SourceSitemap:
+		if p.Sitemap == nil {
+			return nil
+		}
+
+		return p.Sitemap.Check()
+	default:
+		return fmt.Errorf("%w: unknown source %q", ErrInvalidPipeline, p.Source)
+	}
+}
+
+// Queries returns the pipeline's query merged with extra, with url set to the
+// pipeline's upstream.
+func (p Pipeline) Queries(extra url.Values) url.Values {
+	queries, _ := url.ParseQuery(p.Query)
+
+	for key, values := range extra {
+		queries[key] = append(queries[key], values...)
+	}
+
+	queries.Set("url", p.URL)
+
+	return queries
+}
+
+// Parse turns the upstream document into a feed according to Source, within
+// the upstream's limits. Sources needing further documents fetch them through upstream.
+func (p Pipeline) Parse(ctx context.Context, upstream *UpstreamCache, doc *UpstreamDocument) (*gofeed.Feed, error) {
+	source := p.Source
+	if source == "" {
+		source = SourceFeed
+	}
+
+	ctx, span := StartSpan(ctx, "parse", AttrSource.String(string(source)))
+
+	feed, err := p.parse(ctx, upstream, doc)
+	if err != nil && (errors.Is(err, ErrInvalidFeed) || errors.Is(err, ErrLimitExceeded)) {
+		upstream.Metrics.parseFailure(source)
+	}
+
+	if feed != nil {
+		span.SetAttributes(AttrItemsAfter.Int(len(feed.Items)))
+	}
+
+	endSpan(span, err)
+
+	return feed, err
+}
+
+func (p Pipeline) parse(ctx context.Context, upstream *UpstreamCache, doc *UpstreamDocument) (*gofeed.Feed, error) {
+	limits := upstream.Limits
+
+	switch p.Source {
+	case SourceHTML:
+		return limits.parse(ctx, func(context.Context) (*gofeed.Feed, error) {
+			return p.HTML.Parse(doc.Body, doc.URL)
+		})
+	case SourceJSON:
+		return limits.parse(ctx, func(context.Context) (*gofeed.Feed, error) {
+			return p.JSON.Parse(doc.Body, doc.URL)
+		})
+	case SourceSitemap:
+		source := SitemapSource{}
+		if p.Sitemap != nil {
+			source = *p.Sitemap
+		}
+
+		return limits.parse(ctx, func(ctx context.Context) (*gofeed.Feed, error) {
+			return source.Parse(ctx, doc.Body, doc.URL, upstream.fetchBody, limits)
+		})
+	default:
+		return limits.ParseFeed(ctx, doc.Body)
+	}
+}
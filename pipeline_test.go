@@ -0,0 +1,59 @@
+package ff_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func TestPipelineCheck(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name     string
+		pipeline ff.Pipeline
+		err      error
+	}{
+		{"feed", ff.Pipeline{URL: "https://example.com/feed", Query: "title.contains=go"}, nil},
+		{"relative url", ff.Pipeline{URL: "/feed"}, ff.ErrInvalidPipeline},
+		{"bad query", ff.Pipeline{URL: "https://example.com/", Query: "a=%zz"}, ff.ErrInvalidPipeline},
+		{"unknown source", ff.Pipeline{URL: "https://example.com/", Source: "rss2"}, ff.ErrInvalidPipeline},
+		{"html without selectors", ff.Pipeline{URL: "https://example.com/", Source: ff.SourceHTML}, ff.ErrInvalidPipeline},
+		{"json without mappings", ff.Pipeline{URL: "https://example.com/", Source: ff.SourceJSON}, ff.ErrInvalidPipeline},
+		{
+			"html with bad selector",
+			ff.Pipeline{URL: "https://example.com/", Source: ff.SourceHTML, HTML: &ff.HTMLSource{Item: ">>"}},
+			ff.ErrInvalidSelector,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.pipeline.Check()
+			if tt.err == nil {
+				assert.NilError(t, err)
+
+				return
+			}
+
+			assert.Assert(t, errors.Is(err, tt.err), "got %v", err)
+		})
+	}
+}
+
+func TestPipelineQueries(t *testing.T) {
+	t.Parallel()
+
+	pipeline := ff.Pipeline{URL: "https://example.com/feed", Query: "title.contains=go&rm.content"}
+
+	queries := pipeline.Queries(url.Values{"title.contains": {"rust"}, "pipeline": {"news"}})
+	assert.DeepEqual(t, queries, url.Values{
+		"url":            {"https://example.com/feed"},
+		"title.contains": {"go", "rust"},
+		"rm.content":     {""},
+		"pipeline":       {"news"},
+	})
+}
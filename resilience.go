@@ -0,0 +1,279 @@
+package ff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultMaxRetries           = 2
+	defaultRetryBaseDelay       = 200 * time.Millisecond
+	defaultRetryMaxDelay        = 5 * time.Second
+	defaultBreakerThreshold     = 5
+	defaultBreakerCooldown      = time.Minute
+	defaultMaxConcurrentPerHost = 4
+	// maxBackoffShift keeps RetryBaseDelay<<attempt from overflowing.
+	maxBackoffShift = 30
+	// hostSweepInterval is how often idle hosts are forgotten.
+	hostSweepInterval = time.Minute
+)
+
+var ErrCircuitOpen = errors.New("upstream circuit breaker is open")
+
+// ResilienceConfig tunes how upstream failures are handled. Zero values select the defaults.
+type ResilienceConfig struct {
+	// MaxRetries is the number of retries after a failed attempt; -1 disables retries.
+	MaxRetries     int      `json:"maxRetries,omitempty"`
+	RetryBaseDelay Duration `json:"retryBaseDelay,omitempty"`
+	RetryMaxDelay  Duration `json:"retryMaxDelay,omitempty"`
+	// BreakerThreshold consecutive failures open a host's circuit for BreakerCooldown.
+	BreakerThreshold int      `json:"breakerThreshold,omitempty"`
+	BreakerCooldown  Duration `json:"breakerCooldown,omitempty"`
+	// MaxConcurrentPerHost bounds the in-flight requests to one host.
+	MaxConcurrentPerHost int `json:"maxConcurrentPerHost,omitempty"`
+}
+
+func (r ResilienceConfig) withDefaults() ResilienceConfig {
+	if r.MaxRetries == 0 {
+		r.MaxRetries = defaultMaxRetries
+	}
+
+	if r.RetryBaseDelay <= 0 {
+		r.RetryBaseDelay = Duration(defaultRetryBaseDelay)
+	}
+
+	if r.RetryMaxDelay <= 0 {
+		r.RetryMaxDelay = Duration(defaultRetryMaxDelay)
+	}
+
+	if r.BreakerThreshold <= 0 {
+		r.BreakerThreshold = defaultBreakerThreshold
+	}
+
+	if r.BreakerCooldown <= 0 {
+		r.BreakerCooldown = Duration(defaultBreakerCooldown)
+	}
+
+	if r.MaxConcurrentPerHost <= 0 {
+		r.MaxConcurrentPerHost = defaultMaxConcurrentPerHost
+	}
+
+	return r
+}
+
+// backoff returns the delay before retry number attempt (starting at 0):
+// exponential growth capped at RetryMaxDelay, with full jitter.
+func (r ResilienceConfig) backoff(attempt int) time.Duration {
+	delay := time.Duration(r.RetryBaseDelay) << min(attempt, maxBackoffShift)
+	if delay <= 0 || delay > time.Duration(r.RetryMaxDelay) {
+		delay = time.Duration(r.RetryMaxDelay)
+	}
+
+	return rand.N(delay) + 1 // #nosec G404 -- jitter does not need a secure source
+}
+
+// hostState is the per-host circuit breaker and concurrency limiter.
+type hostState struct {
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	slots     chan struct{}
+}
+
+// allow reports whether a request may be sent. Once the cooldown has passed
+// requests are let through again; a single failure then reopens the circuit.
+func (h *hostState) allow(now time.Time) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return !now.Before(h.openUntil)
+}
+
+// idle reports whether the state holds nothing a new one would not: no
+// request in flight, no failure counted and a closed circuit.
+func (h *hostState) idle(now time.Time) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return len(h.slots) == 0 && h.failures == 0 && !now.Before(h.openUntil)
+}
+
+// record updates the breaker and reports whether this result opened it.
+func (h *hostState) record(success bool, now time.Time, config ResilienceConfig) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if success {
+		h.failures = 0
+		h.openUntil = time.Time{}
+
+		return false
+	}
+
+	h.failures++
+	if h.failures < config.BreakerThreshold {
+		return false
+	}
+
+	h.openUntil = now.Add(time.Duration(config.BreakerCooldown))
+
+	return true
+}
+
+func hostOf(upstreamURL string) string {
+	u, err := url.Parse(upstreamURL)
+	if err != nil {
+		return upstreamURL
+	}
+
+	return u.Host
+}
+
+func (u *UpstreamCache) breaker(host string) *hostState {
+	u.hostMutex.Lock()
+	defer u.hostMutex.Unlock()
+
+	// Forget idle hosts now and then, so the map does not grow with every
+	// upstream host ever requested.
+	if now := time.Now(); now.Sub(u.hostsSweptAt) >= hostSweepInterval {
+		for name, state := range u.hosts {
+			if state.idle(now) {
+				delete(u.hosts, name)
+			}
+		}
+
+		u.hostsSweptAt = now
+	}
+
+	state, ok := u.hosts[host]
+	if !ok {
+		state = &hostState{
+			slots: make(chan struct{}, u.Resilience.withDefaults().MaxConcurrentPerHost),
+		}
+		u.hosts[host] = state
+	}
+
+	return state
+}
+
+// acquire waits for a free request slot for host.
+func (u *UpstreamCache) acquire(ctx context.Context, host string) (func(), error) {
+	slots := u.breaker(host).slots
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for upstream slot: %w", ctx.Err())
+	}
+}
+
+// doWithRetry sends the (idempotent) GET, retrying network errors and
+// transient 5xx responses with exponential backoff. No retry is started that
+// could not finish before the deadline of ctx.
+func (u *UpstreamCache) doWithRetry(
+	ctx context.Context, upstreamURL string, cached *upstreamEntry,
+) (*http.Response, error) {
+	config := u.Resilience.withDefaults()
+
+	for attempt := 0; ; attempt++ {
+		req, err := u.newRequest(ctx, upstreamURL, cached)
+		if err != nil {
+			return nil, err
+		}
+
+		started := time.Now()
+		resp, err := u.Client.Do(req) // #nosec G704
+		elapsed := time.Since(started)
+		u.Metrics.upstreamFetch(hostOf(upstreamURL), resp, elapsed)
+
+		if err != nil {
+			err = fmt.Errorf("failed to fetch upstream: %w", err)
+		}
+
+		logFetch(ctx, upstreamURL, attempt, resp, err, elapsed)
+
+		if resp != nil {
+			trace.SpanFromContext(ctx).SetAttributes(AttrUpstreamStatus.Int(resp.StatusCode))
+		}
+
+		if attempt >= config.MaxRetries || ctx.Err() != nil || !retryable(resp, err) {
+			return resp, err
+		}
+
+		delay := config.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to fetch upstream: %w", ctx.Err())
+		}
+	}
+}
+
+// logFetch logs an upstream request attempt; failed attempts are warnings.
+func logFetch(
+	ctx context.Context, upstreamURL string, attempt int, resp *http.Response, err error, elapsed time.Duration,
+) {
+	attrs := []slog.Attr{
+		slog.String("url", upstreamURL),
+		slog.Int("attempt", attempt),
+		slog.Duration("duration", elapsed),
+	}
+
+	level := slog.LevelDebug
+
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("error", err.Error()))
+	} else {
+		attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	}
+
+	Logger(ctx).LogAttrs(ctx, level, "upstream fetch", attrs...)
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrForbiddenUpstream)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	case http.StatusServiceUnavailable:
+		// With Retry-After the upstream asked us to back off instead.
+		return resp.Header.Get("Retry-After") == ""
+	default:
+		return false
+	}
+}
+
+// recordResult feeds the outcome of a fetch to the host's breaker and reports
+// whether it opened the circuit. Refused requests and requests abandoned by
+// our own client say nothing about the upstream's health and are not counted.
+func (u *UpstreamCache) recordResult(ctx context.Context, host string, resp *http.Response, err error) bool {
+	if errors.Is(err, ErrForbiddenUpstream) || ctx.Err() != nil {
+		return false
+	}
+
+	success := err == nil && resp.StatusCode < http.StatusInternalServerError
+
+	return u.breaker(host).record(success, time.Now(), u.Resilience.withDefaults())
+}
@@ -0,0 +1,252 @@
+package ff_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func newResilienceTestCache(t *testing.T, config ff.ResilienceConfig) *ff.UpstreamCache {
+	t.Helper()
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	upstream.Resilience = config
+
+	return upstream
+}
+
+func TestUpstreamCacheRetriesTransientFailures(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(testUpstreamBody))
+	}))
+	defer server.Close()
+
+	upstream := newResilienceTestCache(t, ff.ResilienceConfig{
+		RetryBaseDelay: ff.Duration(time.Millisecond),
+	})
+
+	doc, err := upstream.Fetch(context.Background(), server.URL)
+	assert.NilError(t, err)
+	assert.Equal(t, string(doc.Body), testUpstreamBody)
+	assert.Equal(t, requests.Load(), int32(3))
+}
+
+func TestUpstreamCacheDoesNotRetryClientErrors(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	upstream := newResilienceTestCache(t, ff.ResilienceConfig{
+		RetryBaseDelay: ff.Duration(time.Millisecond),
+	})
+
+	_, err := upstream.Fetch(context.Background(), server.URL)
+	assert.Assert(t, err != nil)
+	assert.Equal(t, requests.Load(), int32(1))
+}
+
+func TestUpstreamCacheCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	var (
+		requests atomic.Int32
+		failing  atomic.Bool
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(testUpstreamBody))
+	}))
+	defer server.Close()
+
+	upstream := newResilienceTestCache(t, ff.ResilienceConfig{
+		MaxRetries:       -1,
+		BreakerThreshold: 2,
+		BreakerCooldown:  ff.Duration(time.Hour),
+	})
+
+	_, err := upstream.Fetch(context.Background(), server.URL)
+	assert.NilError(t, err)
+
+	failing.Store(true)
+
+	_, err = upstream.Fetch(context.Background(), server.URL)
+	assert.Assert(t, err != nil, "a failure below the threshold should be reported")
+
+	doc, err := upstream.Fetch(context.Background(), server.URL)
+	assert.NilError(t, err, "opening the breaker should fall back to the last good copy")
+	assert.Equal(t, string(doc.Body), testUpstreamBody)
+
+	doc, err = upstream.Fetch(context.Background(), server.URL)
+	assert.NilError(t, err)
+	assert.Equal(t, string(doc.Body), testUpstreamBody)
+	assert.Equal(t, requests.Load(), int32(3), "no request should reach the host while the breaker is open")
+
+	_, err = upstream.Fetch(context.Background(), server.URL+"/uncached")
+	assert.Assert(t, errors.Is(err, ff.ErrCircuitOpen))
+}
+
+func TestUpstreamCacheConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+
+	var inFlight, peak atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			old := peak.Load()
+			if current <= old || peak.CompareAndSwap(old, current) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte(testUpstreamBody))
+	}))
+	defer server.Close()
+
+	upstream := newResilienceTestCache(t, ff.ResilienceConfig{MaxConcurrentPerHost: 2})
+
+	done := make(chan error)
+
+	for i := range 6 {
+		go func() {
+			_, err := upstream.Fetch(context.Background(), server.URL+"/"+string(rune('a'+i)))
+			done <- err
+		}()
+	}
+
+	for range 6 {
+		assert.NilError(t, <-done)
+	}
+
+	assert.Assert(t, peak.Load() <= 2, "peak concurrency %d exceeds the limit", peak.Load())
+}
+
+func TestUpstreamCacheRefetchesMissingBodyWithinSlot(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(testUpstreamBody))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	upstream, err := ff.NewUpstreamCacheWithDir(dir)
+	assert.NilError(t, err)
+
+	upstream.Resilience = ff.ResilienceConfig{MaxConcurrentPerHost: 1}
+
+	_, err = upstream.Fetch(context.Background(), server.URL)
+	assert.NilError(t, err)
+
+	bodies, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NilError(t, err)
+
+	for _, body := range bodies {
+		assert.NilError(t, os.Remove(body))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	doc, err := upstream.Fetch(ctx, server.URL)
+	assert.NilError(t, err, "a 304 without a stored body should not wait for its own host slot")
+	assert.Equal(t, string(doc.Body), testUpstreamBody)
+}
+
+func TestUpstreamCacheManyRetries(t *testing.T) {
+	t.Parallel()
+
+	var attempts atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	upstream := newResilienceTestCache(t, ff.ResilienceConfig{
+		MaxRetries:       70,
+		RetryBaseDelay:   ff.Duration(time.Microsecond),
+		RetryMaxDelay:    ff.Duration(time.Microsecond),
+		BreakerThreshold: 100,
+	})
+
+	_, err := upstream.Fetch(context.Background(), server.URL)
+	assert.Assert(t, err != nil)
+	assert.Equal(t, attempts.Load(), int32(71), "long backoff shifts must not overflow")
+}
+
+func TestUpstreamCacheRetriesWithinDeadline(t *testing.T) {
+	t.Parallel()
+
+	var attempts atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	upstream := newResilienceTestCache(t, ff.ResilienceConfig{
+		MaxRetries:     3,
+		RetryBaseDelay: ff.Duration(10 * time.Second),
+		RetryMaxDelay:  ff.Duration(10 * time.Second),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	started := time.Now()
+	_, err := upstream.Fetch(ctx, server.URL)
+
+	var statusErr ff.UpstreamStatusError
+	assert.Assert(t, errors.As(err, &statusErr), "the last response is reported: %v", err)
+	assert.Assert(t, time.Since(started) < time.Second, "no retry is started past the deadline")
+	assert.Assert(t, attempts.Load() >= 1)
+}
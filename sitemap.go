@@ -0,0 +1,185 @@
+package ff
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+// sitemapDateLayouts cover the W3C datetime profile used by <lastmod>.
+var sitemapDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+}
+
+// FetchFunc fetches another upstream document, such as a child sitemap or a page.
+type FetchFunc func(ctx context.Context, url string) ([]byte, error)
+
+// SitemapSource turns a sitemap, or every sitemap of a sitemap index, into a
+// "recently changed pages" feed ordered by <lastmod>, newest first.
+type SitemapSource struct {
+	// FetchPages, when positive, fetches that many of the newest pages to use
+	// their <title> and meta description. Titles default to the page URL.
+	FetchPages int `json:"fetchPages,omitempty"`
+}
+
+// Check reports settings the source cannot work with.
+func (s SitemapSource) Check() error {
+	if s.FetchPages < 0 {
+		return fmt.Errorf("%w: fetchPages cannot be negative", ErrInvalidPipeline)
+	}
+
+	return nil
+}
+
+type sitemapDocument struct {
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// Parse builds the feed from the sitemap at sitemapURL. Child sitemaps of an
+// index and pages are fetched with fetch. Gzip compressed sitemaps are
+// accepted; their decompressed size is bounded by limits.MaxBodySize.
+func (s SitemapSource) Parse(
+	ctx context.Context, body []byte, sitemapURL string, fetch FetchFunc, limits LimitsConfig,
+) (*gofeed.Feed, error) {
+	root, err := decodeSitemap(body, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := root.URLs
+
+	// Sitemap indexes cannot be nested, so children are read one level deep.
+	for _, child := range root.Sitemaps {
+		childBody, err := fetch(ctx, strings.TrimSpace(child.Loc))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch sitemap %s: %w", child.Loc, err)
+		}
+
+		childDocument, err := decodeSitemap(childBody, limits)
+		if err != nil {
+			return nil, fmt.Errorf("sitemap %s: %w", child.Loc, err)
+		}
+
+		entries = append(entries, childDocument.URLs...)
+	}
+
+	feed := &gofeed.Feed{Title: sitemapURL, Link: sitemapURL, Items: make([]*gofeed.Item, 0, len(entries))}
+
+	for _, entry := range entries {
+		loc := strings.TrimSpace(entry.Loc)
+		if loc == "" {
+			continue
+		}
+
+		item := &gofeed.Item{Title: loc, Link: loc, GUID: loc}
+
+		if lastMod, ok := parseSitemapDate(strings.TrimSpace(entry.LastMod)); ok {
+			item.Updated = entry.LastMod
+			item.UpdatedParsed = &lastMod
+			item.PublishedParsed = &lastMod
+		}
+
+		feed.Items = append(feed.Items, item)
+	}
+
+	slices.SortStableFunc(feed.Items, func(a, b *gofeed.Item) int {
+		switch {
+		case a.UpdatedParsed == nil && b.UpdatedParsed == nil:
+			return 0
+		case a.UpdatedParsed == nil:
+			return 1
+		case b.UpdatedParsed == nil:
+			return -1
+		default:
+			return b.UpdatedParsed.Compare(*a.UpdatedParsed)
+		}
+	})
+
+	s.describePages(ctx, feed.Items, fetch)
+
+	return feed, nil
+}
+
+func decodeSitemap(body []byte, limits LimitsConfig) (*sitemapDocument, error) {
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFeed, err)
+		}
+		defer reader.Close()
+
+		body, err = limits.readBody(reader, -1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	document := &sitemapDocument{}
+	if err := xml.Unmarshal(body, document); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFeed, err)
+	}
+
+	if len(document.URLs) == 0 && len(document.Sitemaps) == 0 {
+		return nil, fmt.Errorf("%w: not a sitemap", ErrInvalidFeed)
+	}
+
+	return document, nil
+}
+
+func parseSitemapDate(value string) (time.Time, bool) {
+	for _, layout := range sitemapDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// describePages fills title and description of the newest FetchPages items
+// from the pages themselves. Pages that cannot be fetched keep their URL as title.
+func (s SitemapSource) describePages(ctx context.Context, items []*gofeed.Item, fetch FetchFunc) {
+	var wg sync.WaitGroup
+
+	for _, item := range items[:max(0, min(s.FetchPages, len(items)))] {
+		if _, err := url.Parse(item.Link); err != nil {
+			continue
+		}
+
+		wg.Go(func() {
+			body, err := fetch(ctx, item.Link)
+			if err != nil {
+				return
+			}
+
+			page, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+			if err != nil {
+				return
+			}
+
+			item.Title = cmp.Or(strings.TrimSpace(page.Find("title").First().Text()), item.Title)
+			item.Description, _ = page.Find(`meta[name="description"]`).Attr("content")
+		})
+	}
+
+	wg.Wait()
+}
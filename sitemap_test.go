@@ -0,0 +1,200 @@
+package ff_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+	is "gotest.tools/v3/assert/cmp"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(s))
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func newSitemapServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/sitemap_index.xml", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%[1]s/docs.xml</loc></sitemap>
+  <sitemap><loc>%[1]s/blog.xml.gz</loc></sitemap>
+</sitemapindex>`, server.URL)
+	})
+	mux.HandleFunc("/docs.xml", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>%[1]s/docs/old</loc><lastmod>2023-05-01</lastmod></url>
+  <url><loc>%[1]s/docs/undated</loc></url>
+  <url><loc>%[1]s/docs/new</loc><lastmod>2024-06-01T08:00:00+00:00</lastmod></url>
+</urlset>`, server.URL)
+	})
+	mux.HandleFunc("/blog.xml.gz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(gzipped(t, fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>%s/blog/post</loc><lastmod>2024-01-15</lastmod></url>
+</urlset>`, server.URL)))
+	})
+	mux.HandleFunc("/docs/new", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`<html><head><title>New docs</title>
+<meta name="description" content="What changed"></head></html>`))
+	})
+
+	return server
+}
+
+func TestSitemapSource(t *testing.T) {
+	t.Parallel()
+
+	server := newSitemapServer(t)
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	doc, err := upstream.Fetch(context.Background(), server.URL+"/sitemap_index.xml")
+	assert.NilError(t, err)
+
+	pipeline := ff.Pipeline{
+		URL:     server.URL + "/sitemap_index.xml",
+		Source:  ff.SourceSitemap,
+		Sitemap: &ff.SitemapSource{FetchPages: 1},
+	}
+	assert.NilError(t, pipeline.Check())
+
+	feed, err := pipeline.Parse(context.Background(), upstream, doc)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(feed.Items, 4))
+
+	links := make([]string, 0, len(feed.Items))
+	for _, item := range feed.Items {
+		links = append(links, item.Link)
+	}
+
+	assert.DeepEqual(t, links, []string{
+		server.URL + "/docs/new",
+		server.URL + "/blog/post",
+		server.URL + "/docs/old",
+		server.URL + "/docs/undated",
+	})
+
+	newest := feed.Items[0]
+	assert.Equal(t, newest.Title, "New docs")
+	assert.Equal(t, newest.Description, "What changed")
+	assert.Assert(t, newest.UpdatedParsed.Equal(time.Date(2024, time.June, 1, 8, 0, 0, 0, time.UTC)))
+
+	assert.Equal(t, feed.Items[1].Title, server.URL+"/blog/post", "only FetchPages pages are fetched")
+	assert.Assert(t, feed.Items[3].UpdatedParsed == nil)
+}
+
+func TestSitemapSourceErrors(t *testing.T) {
+	t.Parallel()
+
+	noFetch := func(context.Context, string) ([]byte, error) {
+		return nil, errors.New("unexpected fetch")
+	}
+
+	_, err := ff.SitemapSource{}.Parse(context.Background(), []byte("<rss/>"), "https://example.com/", noFetch, ff.LimitsConfig{})
+	assert.Assert(t, errors.Is(err, ff.ErrInvalidFeed))
+
+	bomb := gzipped(t, `<urlset><url><loc>https://example.com/`+string(bytes.Repeat([]byte("a"), 4096))+`</loc></url></urlset>`)
+	_, err = ff.SitemapSource{}.Parse(context.Background(), bomb, "https://example.com/", noFetch,
+		ff.LimitsConfig{MaxBodySize: 1024})
+	assert.Assert(t, errors.Is(err, ff.ErrLimitExceeded), "decompressed sitemaps are bounded by MaxBodySize")
+
+	negative := ff.SitemapSource{FetchPages: -1}
+	assert.Assert(t, errors.Is(negative.Check(), ff.ErrInvalidPipeline))
+
+	pipeline := ff.Pipeline{URL: "https://example.com/sitemap.xml", Source: ff.SourceSitemap, Sitemap: &negative}
+	assert.Assert(t, errors.Is(pipeline.Check(), ff.ErrInvalidPipeline))
+
+	feed, err := negative.Parse(context.Background(),
+		[]byte(`<urlset><url><loc>https://example.com/a</loc></url></urlset>`), "https://example.com/", noFetch,
+		ff.LimitsConfig{})
+	assert.NilError(t, err, "a negative FetchPages fetches no page")
+	assert.Equal(t, len(feed.Items), 1)
+}
+
+func TestSitemapCacheFollowsChildSitemaps(t *testing.T) {
+	t.Parallel()
+
+	var childVersion atomic.Int32
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/sitemap_index.xml", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"index"` {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("ETag", `"index"`)
+		fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%s/child.xml</loc></sitemap></sitemapindex>`, server.URL)
+	})
+	mux.HandleFunc("/child.xml", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, `<urlset><url><loc>%s/page/%d</loc></url></urlset>`, server.URL, childVersion.Load())
+	})
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	pipeline := ff.Pipeline{URL: server.URL + "/sitemap_index.xml", Source: ff.SourceSitemap}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := upstream.Fetch(r.Context(), pipeline.URL)
+		assert.NilError(t, err)
+
+		feed, err := pipeline.Parse(r.Context(), upstream, doc)
+		assert.NilError(t, err)
+
+		for _, item := range feed.Items {
+			fmt.Fprintln(w, item.Link)
+		}
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(handler, t.TempDir())
+	assert.NilError(t, err)
+
+	middleware.Upstream = upstream
+
+	serve := func() string {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodGet,
+			"/?url="+url.QueryEscape(pipeline.URL), nil)
+		w := httptest.NewRecorder()
+		middleware.ServeHTTP(w, req)
+
+		return w.Body.String()
+	}
+
+	assert.Equal(t, serve(), server.URL+"/page/0\n")
+	assert.Equal(t, serve(), server.URL+"/page/0\n")
+
+	childVersion.Store(1)
+	assert.Equal(t, serve(), server.URL+"/page/1\n", "a changed child sitemap should invalidate the feed")
+}
@@ -0,0 +1,82 @@
+package ff
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/nakatanakatana/ff"
+
+// Span attribute keys set by ff.
+const (
+	AttrUpstreamHost   = attribute.Key("ff.upstream.host")
+	AttrUpstreamURL    = attribute.Key("ff.upstream.url")
+	AttrUpstreamStatus = attribute.Key("ff.upstream.status")
+	AttrSource         = attribute.Key("ff.source")
+	AttrItemsBefore    = attribute.Key("ff.items.before")
+	AttrItemsAfter     = attribute.Key("ff.items.after")
+	AttrCacheOutcome   = attribute.Key("ff.cache.outcome")
+	AttrStatus         = attribute.Key("http.response.status_code")
+)
+
+// TraceRequests starts a span for every request with the tracers of provider,
+// continuing a trace context sent by the client. The fetch, parse, filter and
+// render stages below it create child spans from the request context. A nil
+// provider leaves next untraced.
+func TraceRequests(provider trace.TracerProvider, next http.Handler) http.Handler {
+	if provider == nil {
+		return next
+	}
+
+	tracer := provider.Tracer(tracerName)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttributes(AttrStatus.Int(sw.status))
+
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// StartSpan starts a span named name as a child of the span in ctx, with the
+// tracer provider that span came from. Without a span in ctx the global
+// provider is used, which does nothing unless one was installed.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	provider := trace.SpanFromContext(ctx).TracerProvider()
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		provider = otel.GetTracerProvider()
+	}
+
+	return provider.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...)) //nolint:spancheck
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
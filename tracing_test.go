@@ -0,0 +1,117 @@
+package ff_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/nakatanakatana/ff"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gotest.tools/v3/assert"
+)
+
+const testTracedFeed = `<rss version="2.0"><channel><title>t</title>
+<item><title>a</title></item><item><title>b</title></item>
+</channel></rss>`
+
+func newTestTracerProvider(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+	})
+
+	return provider, exporter
+}
+
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value, len(span.Attributes))
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+
+	return attrs
+}
+
+func TestTraceRequests(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(testTracedFeed))
+	}))
+	defer server.Close()
+
+	provider, exporter := newTestTracerProvider(t)
+
+	upstream, err := ff.NewUpstreamCacheWithDir(t.TempDir())
+	assert.NilError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, err := upstream.Fetch(r.Context(), server.URL)
+		assert.NilError(t, err)
+
+		feed, err := ff.Pipeline{}.Parse(r.Context(), upstream, doc)
+		assert.NilError(t, err)
+
+		drop := func(context.Context, *gofeed.Item) bool { return false }
+		_, err = ff.Apply(r.Context(), feed, []ff.FilterFunc{drop}, nil)
+		assert.NilError(t, err)
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	middleware, err := ff.NewCacheMiddlewareWithDir(handler, t.TempDir())
+	assert.NilError(t, err)
+
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/?url="+server.URL, nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ff.TraceRequests(provider, middleware).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub, len(spans))
+
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+
+	assert.Equal(t, len(spans), 4)
+
+	root := byName["GET /"]
+	assert.Equal(t, root.SpanContext.TraceID().String(), "4bf92f3577b34da6a3ce929d0e0e4736",
+		"the client's trace is continued")
+	assert.Equal(t, spanAttributes(root)[ff.AttrCacheOutcome].AsString(), ff.CacheMiss)
+	assert.Equal(t, spanAttributes(root)[ff.AttrStatus].AsInt64(), int64(http.StatusAccepted))
+
+	fetch := spanAttributes(byName["upstream.fetch"])
+	assert.Equal(t, fetch[ff.AttrUpstreamStatus].AsInt64(), int64(http.StatusOK))
+	assert.Equal(t, fetch[ff.AttrUpstreamHost].AsString(), server.Listener.Addr().String())
+
+	parse := spanAttributes(byName["parse"])
+	assert.Equal(t, parse[ff.AttrSource].AsString(), string(ff.SourceFeed))
+	assert.Equal(t, parse[ff.AttrItemsAfter].AsInt64(), int64(2))
+
+	filter := spanAttributes(byName["filter"])
+	assert.Equal(t, filter[ff.AttrItemsBefore].AsInt64(), int64(2))
+	assert.Equal(t, filter[ff.AttrItemsAfter].AsInt64(), int64(0))
+
+	for _, name := range []string{"upstream.fetch", "parse", "filter"} {
+		assert.Equal(t, byName[name].Parent.SpanID(), root.SpanContext.SpanID(), name)
+	}
+}
+
+func TestTraceRequestsNilProvider(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+	ff.TraceRequests(nil, http.NotFoundHandler()).ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
@@ -0,0 +1,527 @@
+package ff
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// UpstreamStatusError is returned when an upstream answers with a non-2xx status.
+type UpstreamStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e UpstreamStatusError) Error() string {
+	return "upstream http error: " + e.Status
+}
+
+// UpstreamDocument is a raw upstream response body kept by UpstreamCache.
+type UpstreamDocument struct {
+	URL          string
+	Body         []byte
+	ETag         string
+	LastModified string
+	// Version identifies the body content, so rendered outputs can tell
+	// whether the document they were built from has changed.
+	Version   string
+	FetchedAt time.Time
+	// Header holds the response headers of the fetch that produced Body.
+	Header http.Header
+}
+
+type upstreamEntry struct {
+	path         string
+	etag         string
+	lastModified string
+	version      string
+	fetchedAt    time.Time
+	freshUntil   time.Time
+	header       http.Header
+}
+
+// UpstreamCache stores upstream documents keyed by normalised URL and revalidates them
+// with conditional GETs, so every pipeline over the same upstream shares one fetch.
+type UpstreamCache struct {
+	Dir        string
+	Client     *http.Client
+	Resilience ResilienceConfig
+	Limits     LimitsConfig
+	// Files restricts the directories file:// upstreams are read from.
+	Files FileConfig
+	// Metrics, when set, times every upstream request and counts parse failures.
+	Metrics    *Metrics
+	entries    map[string]*upstreamEntry
+	retryAfter map[string]time.Time
+	entryMutex sync.RWMutex
+	hosts      map[string]*hostState
+	// hostsSweptAt is when idle hosts were last removed from hosts.
+	hostsSweptAt time.Time
+	hostMutex    sync.Mutex
+}
+
+func NewUpstreamCache() (*UpstreamCache, error) {
+	return NewUpstreamCacheWithDir(filepath.Join(os.TempDir(), "ff-cache", "upstream"))
+}
+
+func NewUpstreamCacheWithDir(cacheDir string) (*UpstreamCache, error) {
+	if err := os.MkdirAll(cacheDir, dirPerms); err != nil {
+		return nil, fmt.Errorf("failed to create upstream cache directory: %w", err)
+	}
+
+	client, err := NewUpstreamClient(ClientConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &UpstreamCache{
+		Dir:        cacheDir,
+		Client:     client,
+		entries:    make(map[string]*upstreamEntry),
+		retryAfter: make(map[string]time.Time),
+		hosts:      make(map[string]*hostState),
+	}, nil
+}
+
+// Fetch returns the upstream document. A cached copy is served without any
+// request while it is fresh, while the upstream asked us to back off or while
+// the host's circuit breaker is open, and is otherwise revalidated with
+// If-None-Match / If-Modified-Since so the body is downloaded only when it changed.
+// file:// upstreams are read from the directories allowed by Files. A document
+// the request already fetched, such as the one a cache freshness check found
+// changed, is reused as it is.
+func (u *UpstreamCache) Fetch(ctx context.Context, upstreamURL string) (*UpstreamDocument, error) {
+	doc := fetchedDocument(ctx, upstreamURL)
+	if doc == nil {
+		spanCtx, span := StartSpan(ctx, "upstream.fetch",
+			AttrUpstreamHost.String(hostOf(upstreamURL)), AttrUpstreamURL.String(upstreamURL))
+
+		var err error
+
+		doc, err = u.fetch(spanCtx, upstreamURL)
+		endSpan(span, err)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if record, ok := ctx.Value(fetchRecordKey{}).(*fetchRecord); ok {
+		record.add(doc)
+	}
+
+	return doc, nil
+}
+
+func (u *UpstreamCache) fetch(ctx context.Context, upstreamURL string) (*UpstreamDocument, error) {
+	if isFileURL(upstreamURL) {
+		return u.fetchFile(upstreamURL)
+	}
+
+	cached := u.lookup(upstreamURL)
+	if cached != nil {
+		now := time.Now()
+		if now.Before(cached.freshUntil) || now.Before(u.retryAfterOf(upstreamURL)) {
+			if doc := cached.load(upstreamURL); doc != nil {
+				return doc, nil
+			}
+		}
+	}
+
+	host := hostOf(upstreamURL)
+	if !u.breaker(host).allow(time.Now()) {
+		if doc := cached.load(upstreamURL); doc != nil {
+			return doc, nil
+		}
+
+		return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+	}
+
+	release, err := u.acquire(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return u.download(ctx, upstreamURL, host, cached)
+}
+
+// download requests the upstream, conditionally when cached is set. The caller
+// holds a slot for host.
+func (u *UpstreamCache) download(
+	ctx context.Context, upstreamURL string, host string, cached *upstreamEntry,
+) (*UpstreamDocument, error) {
+	resp, err := u.doWithRetry(ctx, upstreamURL, cached)
+	if u.recordResult(ctx, host, resp, err) {
+		// This failure opened the breaker: fall back to the last good copy.
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if doc := cached.load(upstreamURL); doc != nil {
+			return doc, nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		if doc := u.revalidated(upstreamURL, cached, resp.Header); doc != nil {
+			return doc, nil
+		}
+
+		// The stored body is gone, so fetch it again without validators,
+		// within the slot already held.
+		u.Remove(upstreamURL)
+
+		return u.download(ctx, upstreamURL, host, nil)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return u.failed(upstreamURL, cached, resp)
+	}
+
+	body, err := u.Limits.readBody(resp.Body, resp.ContentLength)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := u.store(upstreamURL, resp.Header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.document(upstreamURL, body), nil
+}
+
+func (u *UpstreamCache) newRequest(
+	ctx context.Context, upstreamURL string, cached *upstreamEntry,
+) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstreamURL, nil) // #nosec G704
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upstream request: %w", err)
+	}
+
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	return req, nil
+}
+
+// revalidated refreshes a cached entry after a 304 response, taking over the
+// caching headers the upstream sent with it. It returns nil when the stored
+// body is gone.
+func (u *UpstreamCache) revalidated(upstreamURL string, cached *upstreamEntry, header http.Header) *UpstreamDocument {
+	body, err := os.ReadFile(cached.path)
+	if err != nil {
+		return nil
+	}
+
+	merged := cached.header.Clone()
+	if merged == nil {
+		merged = http.Header{}
+	}
+
+	for _, key := range []string{"Cache-Control", "Expires", "Date", "Age", "ETag"} {
+		if value := header.Get(key); value != "" {
+			merged.Set(key, value)
+		}
+	}
+
+	cached.header = merged
+	if etag := header.Get("ETag"); etag != "" {
+		cached.etag = etag
+	}
+
+	cached.fetchedAt = time.Now()
+	cached.freshUntil = cached.document(upstreamURL, body).FreshUntil()
+
+	u.entryMutex.Lock()
+	u.entries[NormalizeURL(upstreamURL)] = cached
+	u.entryMutex.Unlock()
+
+	return cached.document(upstreamURL, body)
+}
+
+// failed handles a non-2xx upstream response. Rate limiting responses carrying
+// Retry-After make us back off, serving the cached copy until then.
+func (u *UpstreamCache) failed(
+	upstreamURL string, cached *upstreamEntry, resp *http.Response,
+) (*UpstreamDocument, error) {
+	statusErr := UpstreamStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, statusErr
+	}
+
+	if until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		u.entryMutex.Lock()
+		u.retryAfter[NormalizeURL(upstreamURL)] = until
+		u.entryMutex.Unlock()
+	}
+
+	if doc := cached.load(upstreamURL); doc != nil {
+		return doc, nil
+	}
+
+	return nil, statusErr
+}
+
+func (u *UpstreamCache) retryAfterOf(upstreamURL string) time.Time {
+	u.entryMutex.RLock()
+	defer u.entryMutex.RUnlock()
+
+	return u.retryAfter[NormalizeURL(upstreamURL)]
+}
+
+type documentKey struct{}
+
+// contextWithDocuments returns a context carrying the documents just fetched,
+// for Fetch to reuse instead of contacting the upstreams again.
+func contextWithDocuments(ctx context.Context, fetched *fetchRecord) context.Context {
+	return context.WithValue(ctx, documentKey{}, fetched)
+}
+
+func fetchedDocument(ctx context.Context, upstreamURL string) *UpstreamDocument {
+	fetched, ok := ctx.Value(documentKey{}).(*fetchRecord)
+	if !ok {
+		return nil
+	}
+
+	return fetched.document(upstreamURL)
+}
+
+// fetchRecord collects the documents Fetch returned while a response was
+// rendered, so the rendered entry is tagged with the versions it was built
+// from rather than whatever the shared cache holds once rendering is done.
+type fetchRecord struct {
+	mutex sync.Mutex
+	docs  map[string]*UpstreamDocument
+}
+
+type fetchRecordKey struct{}
+
+// contextWithFetchRecord returns a context in which Fetch adds its documents
+// to the returned record.
+func contextWithFetchRecord(ctx context.Context) (context.Context, *fetchRecord) {
+	record := &fetchRecord{docs: make(map[string]*UpstreamDocument)}
+
+	return context.WithValue(ctx, fetchRecordKey{}, record), record
+}
+
+func (r *fetchRecord) add(doc *UpstreamDocument) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.docs[NormalizeURL(doc.URL)] = doc
+}
+
+// document is the document fetched for upstreamURL, or nil.
+func (r *fetchRecord) document(upstreamURL string) *UpstreamDocument {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.docs[NormalizeURL(upstreamURL)]
+}
+
+// version is the version of the document fetched for upstreamURL, or "".
+func (r *fetchRecord) version(upstreamURL string) string {
+	if doc := r.document(upstreamURL); doc != nil {
+		return doc.Version
+	}
+
+	return ""
+}
+
+// versionsExcept returns the versions of the documents fetched besides
+// upstreamURL, such as child sitemaps and pages, by normalised URL.
+func (r *fetchRecord) versionsExcept(upstreamURL string) map[string]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	versions := make(map[string]string, len(r.docs))
+
+	for key, doc := range r.docs {
+		if key != NormalizeURL(upstreamURL) {
+			versions[key] = doc.Version
+		}
+	}
+
+	return versions
+}
+
+// fetchBody is Fetch as a FetchFunc.
+func (u *UpstreamCache) fetchBody(ctx context.Context, upstreamURL string) ([]byte, error) {
+	doc, err := u.Fetch(ctx, upstreamURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return doc.Body, nil
+}
+
+// Cached returns the last document stored for upstreamURL without contacting
+// the upstream, or nil.
+func (u *UpstreamCache) Cached(upstreamURL string) *UpstreamDocument {
+	return u.lookup(upstreamURL).load(upstreamURL)
+}
+
+// Version returns the version of the cached document without contacting the upstream.
+func (u *UpstreamCache) Version(upstreamURL string) string {
+	entry := u.lookup(upstreamURL)
+	if entry == nil {
+		return ""
+	}
+
+	return entry.version
+}
+
+func (u *UpstreamCache) Remove(upstreamURL string) {
+	u.entryMutex.Lock()
+	defer u.entryMutex.Unlock()
+
+	key := NormalizeURL(upstreamURL)
+
+	if entry, ok := u.entries[key]; ok {
+		os.Remove(entry.path)
+		delete(u.entries, key)
+	}
+
+	delete(u.retryAfter, key)
+}
+
+func (u *UpstreamCache) RemoveAll() {
+	u.entryMutex.Lock()
+	defer u.entryMutex.Unlock()
+
+	for _, entry := range u.entries {
+		os.Remove(entry.path)
+	}
+
+	clear(u.entries)
+	clear(u.retryAfter)
+}
+
+func (u *UpstreamCache) lookup(upstreamURL string) *upstreamEntry {
+	u.entryMutex.RLock()
+	defer u.entryMutex.RUnlock()
+
+	entry, ok := u.entries[NormalizeURL(upstreamURL)]
+	if !ok {
+		return nil
+	}
+
+	copied := *entry
+
+	return &copied
+}
+
+func (u *UpstreamCache) store(upstreamURL string, header http.Header, body []byte) (*upstreamEntry, error) {
+	path := filepath.Join(u.Dir, fmt.Sprintf("%x.xml", sha256.Sum256([]byte(NormalizeURL(upstreamURL)))))
+
+	// Write through a temporary file so concurrent readers never see a partial body.
+	tmp, err := os.CreateTemp(u.Dir, "upstream-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upstream cache file: %w", err)
+	}
+
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return nil, fmt.Errorf("failed to write upstream cache file: %w", err)
+	}
+
+	tmp.Close()
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+
+		return nil, fmt.Errorf("failed to write upstream cache file: %w", err)
+	}
+
+	entry := &upstreamEntry{
+		path:         path,
+		etag:         header.Get("ETag"),
+		lastModified: header.Get("Last-Modified"),
+		version:      fmt.Sprintf("%x", sha256.Sum256(body)),
+		fetchedAt:    time.Now(),
+		header:       header.Clone(),
+	}
+	entry.freshUntil = entry.document(upstreamURL, body).FreshUntil()
+
+	u.entryMutex.Lock()
+	defer u.entryMutex.Unlock()
+
+	key := NormalizeURL(upstreamURL)
+	u.entries[key] = entry
+	delete(u.retryAfter, key)
+	u.evict()
+
+	copied := *entry
+
+	return &copied, nil
+}
+
+// evict removes the least recently fetched documents beyond
+// Limits.MaxCachedDocuments. The caller holds entryMutex.
+func (u *UpstreamCache) evict() {
+	limit := u.Limits.withDefaults().MaxCachedDocuments
+
+	for limit > 0 && len(u.entries) > limit {
+		var (
+			oldestKey string
+			oldest    *upstreamEntry
+		)
+
+		for key, entry := range u.entries {
+			if oldest == nil || entry.fetchedAt.Before(oldest.fetchedAt) {
+				oldestKey, oldest = key, entry
+			}
+		}
+
+		os.Remove(oldest.path)
+		delete(u.entries, oldestKey)
+	}
+}
+
+// load reads the cached body back, returning nil when there is nothing to serve.
+func (e *upstreamEntry) load(upstreamURL string) *UpstreamDocument {
+	if e == nil {
+		return nil
+	}
+
+	body, err := os.ReadFile(e.path)
+	if err != nil {
+		return nil
+	}
+
+	return e.document(upstreamURL, body)
+}
+
+func (e *upstreamEntry) document(upstreamURL string, body []byte) *UpstreamDocument {
+	return &UpstreamDocument{
+		URL:          upstreamURL,
+		Body:         body,
+		ETag:         e.etag,
+		LastModified: e.lastModified,
+		Version:      e.version,
+		FetchedAt:    e.fetchedAt,
+		Header:       e.header.Clone(),
+	}
+}
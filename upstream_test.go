@@ -0,0 +1,154 @@
+package ff_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+const testUpstreamBody = `<?xml version="1.0" encoding="UTF-8" ?>
+<rss version="2.0"><channel><title>RSS Title</title></channel></rss>`
+
+func TestUpstreamCacheConditionalGet(t *testing.T) {
+	t.Parallel()
+
+	var fullFetches, notModified atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		fullFetches.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(testUpstreamBody))
+	}))
+	defer server.Close()
+
+	upstream, err := ff.NewUpstreamCache()
+	assert.NilError(t, err)
+
+	first, err := upstream.Fetch(context.Background(), server.URL)
+	assert.NilError(t, err)
+	assert.Equal(t, string(first.Body), testUpstreamBody)
+	assert.Equal(t, first.ETag, `"v1"`)
+
+	second, err := upstream.Fetch(context.Background(), server.URL)
+	assert.NilError(t, err)
+	assert.Equal(t, string(second.Body), testUpstreamBody)
+	assert.Equal(t, second.Version, first.Version)
+	assert.Equal(t, upstream.Version(server.URL), first.Version)
+
+	assert.Equal(t, fullFetches.Load(), int32(1), "body should be downloaded once")
+	assert.Equal(t, notModified.Load(), int32(1), "second fetch should be a conditional GET")
+}
+
+func TestUpstreamCacheStatusError(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	upstream, err := ff.NewUpstreamCache()
+	assert.NilError(t, err)
+
+	_, err = upstream.Fetch(context.Background(), server.URL)
+
+	var statusErr ff.UpstreamStatusError
+	assert.Assert(t, errors.As(err, &statusErr))
+	assert.Equal(t, statusErr.StatusCode, http.StatusNotFound)
+	assert.Equal(t, upstream.Version(server.URL), "")
+}
+
+func TestUpstreamCacheServesFreshDocumentWithoutRequest(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(testUpstreamBody))
+	}))
+	defer server.Close()
+
+	upstream, err := ff.NewUpstreamCache()
+	assert.NilError(t, err)
+
+	for range 3 {
+		doc, err := upstream.Fetch(context.Background(), server.URL)
+		assert.NilError(t, err)
+		assert.Equal(t, string(doc.Body), testUpstreamBody)
+	}
+
+	assert.Equal(t, requests.Load(), int32(1), "fresh documents should not be revalidated")
+}
+
+func TestUpstreamCacheRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) > 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(testUpstreamBody))
+	}))
+	defer server.Close()
+
+	upstream, err := ff.NewUpstreamCache()
+	assert.NilError(t, err)
+
+	for range 3 {
+		doc, err := upstream.Fetch(context.Background(), server.URL)
+		assert.NilError(t, err, "the cached copy should be served while rate limited")
+		assert.Equal(t, string(doc.Body), testUpstreamBody)
+	}
+
+	assert.Equal(t, requests.Load(), int32(2), "no request should be sent before Retry-After")
+}
+
+func TestUpstreamCacheEvictsBeyondMaxCachedDocuments(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(testUpstreamBody))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	upstream, err := ff.NewUpstreamCacheWithDir(dir)
+	assert.NilError(t, err)
+
+	upstream.Limits = ff.LimitsConfig{MaxCachedDocuments: 2}
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		_, err := upstream.Fetch(context.Background(), server.URL+path)
+		assert.NilError(t, err)
+	}
+
+	assert.Assert(t, upstream.Cached(server.URL+"/a") == nil, "the least recently fetched document is evicted")
+	assert.Assert(t, upstream.Cached(server.URL+"/c") != nil)
+
+	files, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 2)
+}
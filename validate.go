@@ -0,0 +1,82 @@
+package ff
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"time"
+)
+
+var (
+	ErrUnknownKey   = errors.New("unknown query parameter")
+	ErrInvalidParam = errors.New("invalid query parameter value")
+
+	errNoValue = errors.New("takes no value")
+)
+
+// paramChecks validate the values of the filters and modifiers that do not
+// accept arbitrary strings. Filters silently ignore values they cannot use.
+var paramChecks = map[string]func(string) error{
+	"updated_at.from":     checkTime,
+	"published_at.from":   checkTime,
+	"updated_at.latest":   checkEmpty,
+	"published_at.latest": checkEmpty,
+	"latest":              checkEmpty,
+	"mute_authors":        checkEmpty,
+	"mute_urls":           checkEmpty,
+	"rm.description":      checkEmpty,
+	"rm.content":          checkEmpty,
+}
+
+func checkTime(value string) error {
+	if _, err := time.Parse(time.RFC3339, value); err != nil {
+		return fmt.Errorf("want an RFC 3339 time: %w", err)
+	}
+
+	return nil
+}
+
+func checkEmpty(value string) error {
+	if value != "" {
+		return fmt.Errorf("%w, %q is ignored", errNoValue, value)
+	}
+
+	return nil
+}
+
+// ValidateQuery reports the query parameters ff would ignore: keys that are
+// neither filters, modifiers, pipeline options nor one of options, and values
+// a filter cannot use. Problems are returned sorted by key.
+func ValidateQuery(
+	queries url.Values, filtersMap FilterFuncMap, modifiersMap ModifierFuncMap, options ...string,
+) []error {
+	keys := slices.Sorted(maps.Keys(queries))
+
+	var problems []error
+
+	for _, key := range keys {
+		_, isFilter := filtersMap[key]
+		_, isModifier := modifiersMap[key]
+
+		if !isFilter && !isModifier && !slices.Contains(pipelineOptionKeys, key) && !slices.Contains(options, key) {
+			problems = append(problems, fmt.Errorf("%w: %s", ErrUnknownKey, key))
+
+			continue
+		}
+
+		check, ok := paramChecks[key]
+		if !ok {
+			continue
+		}
+
+		for _, value := range queries[key] {
+			if err := check(value); err != nil {
+				problems = append(problems, fmt.Errorf("%w: %s: %w", ErrInvalidParam, key, err))
+			}
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,44 @@
+package ff_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/nakatanakatana/ff"
+	"gotest.tools/v3/assert"
+)
+
+func TestValidateQuery(t *testing.T) {
+	t.Parallel()
+
+	filtersMap := ff.CreateFiltersMap(nil, nil)
+	modifiersMap := ff.CreateModifierMap()
+
+	for _, tt := range []struct {
+		name   string
+		query  string
+		expect []error
+	}{
+		{"empty", "", nil},
+		{"valid", "url=https://example.com/&title.contains=go&published_at.from=2024-01-01T00:00:00Z&rm.content", nil},
+		{"extra option", "format=atom", nil},
+		{"unknown key", "utm_source=x&titel.contains=go", []error{ff.ErrUnknownKey, ff.ErrUnknownKey}},
+		{"bad time", "updated_at.from=2024-01-01", []error{ff.ErrInvalidParam}},
+		{"value for a flag", "latest=yes", []error{ff.ErrInvalidParam}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			queries, err := url.ParseQuery(tt.query)
+			assert.NilError(t, err)
+
+			problems := ff.ValidateQuery(queries, filtersMap, modifiersMap, "format")
+			assert.Equal(t, len(problems), len(tt.expect), "%v", problems)
+
+			for i, problem := range problems {
+				assert.Assert(t, errors.Is(problem, tt.expect[i]), problem)
+			}
+		})
+	}
+}